@@ -0,0 +1,93 @@
+/*
+ * SPDX-License-Identifier: AGPL-3.0-or-later
+ * Copyright 2020 Kopano and its licensors
+ */
+
+package kcoidc
+
+import (
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// Header values used by JWT access tokens as defined in RFC 9068.
+const (
+	JWTAccessTokenType      = "at+jwt"
+	JWTAccessTokenMediaType = "application/at+jwt"
+)
+
+// IsJWTAccessTokenHeader returns true if the provided JOSE header marks the
+// token as JWT access token as defined in RFC 9068.
+func IsJWTAccessTokenHeader(header map[string]interface{}) bool {
+	typ, _ := header["typ"].(string)
+	switch strings.ToLower(typ) {
+	case JWTAccessTokenType, JWTAccessTokenMediaType:
+		return true
+	}
+
+	return false
+}
+
+// tokenTypeWithHeader returns the numeric token type of the provided claims as
+// defined by the provided claims profile. Tokens which the profile considers
+// standard tokens are access tokens if the provided header marks them as JWT
+// access token.
+func tokenTypeWithHeader(profile ClaimsProfile, header map[string]interface{}, claims *ExtraClaimsWithType) int {
+	tokenType := profile.TokenType(claims)
+	if tokenType == TokenTypeStandard && IsJWTAccessTokenHeader(header) {
+		return TokenTypeAccess
+	}
+
+	return tokenType
+}
+
+// audienceFromValue returns the audience of the provided aud claim value, which
+// can either be a single string or an array of strings.
+func audienceFromValue(value interface{}) []string {
+	switch vt := value.(type) {
+	case string:
+		if vt != "" {
+			return []string{vt}
+		}
+	case []interface{}:
+		audience := make([]string, 0, len(vt))
+		for _, v := range vt {
+			if s, _ := v.(string); s != "" {
+				audience = append(audience, s)
+			}
+		}
+		return audience
+	}
+
+	return nil
+}
+
+// validateJWTAccessToken checks the provided header and claims according to
+// RFC 9068. If the header marks the token as JWT access token, all claims which
+// are required by RFC 9068 must be present. The audience is passed as the
+// original aud claim value, since jwt.StandardClaims does not support arrays.
+// If required is true, tokens which are not marked as JWT access token are
+// rejected.
+func validateJWTAccessToken(header map[string]interface{}, audience interface{}, standardClaims *jwt.StandardClaims, claims *ExtraClaimsWithType, required bool) error {
+	if !IsJWTAccessTokenHeader(header) {
+		if required {
+			return ErrStatusTokenUnexpectedType
+		}
+		return nil
+	}
+
+	if standardClaims.Issuer == "" ||
+		standardClaims.Subject == "" ||
+		standardClaims.ExpiresAt == 0 ||
+		standardClaims.IssuedAt == 0 ||
+		standardClaims.Id == "" ||
+		len(audienceFromValue(audience)) == 0 {
+		return ErrStatusTokenValidationFailed
+	}
+	if clientID, _ := (*claims)[ClientIDClaim].(string); clientID == "" {
+		return ErrStatusTokenValidationFailed
+	}
+
+	return nil
+}
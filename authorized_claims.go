@@ -0,0 +1,128 @@
+/*
+ * SPDX-License-Identifier: AGPL-3.0-or-later
+ * Copyright 2020 Kopano and its licensors
+ */
+
+package kcoidc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Sections of claims requests as defined by OpenID Connect Core 1.0.
+const (
+	ClaimsRequestUserInfo = "userinfo"
+	ClaimsRequestIDToken  = "id_token"
+)
+
+// A ClaimRequest describes a single requested claim as defined in OpenID
+// Connect Core 1.0 section 5.5.1.
+type ClaimRequest struct {
+	Essential bool          `json:"essential,omitempty"`
+	Value     interface{}   `json:"value,omitempty"`
+	Values    []interface{} `json:"values,omitempty"`
+}
+
+// A ClaimsRequest maps sections like ClaimsRequestUserInfo to the requested
+// claims in that section, as defined in OpenID Connect Core 1.0 section 5.5.
+// Requested claims without further requirements are nil.
+type ClaimsRequest map[string]map[string]*ClaimRequest
+
+// ParseClaimsRequest parses the provided JSON claims request.
+func ParseClaimsRequest(data []byte) (ClaimsRequest, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	request := make(ClaimsRequest)
+	if err := decoder.Decode(&request); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrStatusInvalidClaimsRequest, err)
+	}
+
+	return request, nil
+}
+
+// RequireAuthorizedClaims returns nil if all the claims of the provided claims
+// request are authorized by the authorized claims of the provided claims.
+// Otherwise an error is returned. Required claims marked as essential must be
+// authorized as essential, and required claims with value or values must be
+// authorized with a matching value. The returned error is a
+// *MissingClaimsError.
+func RequireAuthorizedClaims(claims *ExtraClaimsWithType, requiredClaims ClaimsRequest) error {
+	if len(requiredClaims) == 0 {
+		return nil
+	}
+
+	authorizedClaims := AuthorizedClaimsFromClaims(claims)
+	missingClaims := make([]string, 0)
+	for section, requested := range requiredClaims {
+		authorizedSection, _ := authorizedClaims[section].(map[string]interface{})
+		for name, request := range requested {
+			authorized, ok := authorizedSection[name]
+			if !ok || !isClaimRequestAuthorized(request, authorized) {
+				missingClaims = append(missingClaims, section+"."+name)
+			}
+		}
+	}
+	if len(missingClaims) == 0 {
+		return nil
+	}
+
+	sort.Strings(missingClaims)
+	return &MissingClaimsError{
+		Claims: missingClaims,
+	}
+}
+
+func isClaimRequestAuthorized(request *ClaimRequest, authorized interface{}) bool {
+	if request == nil {
+		return true
+	}
+
+	authorizedRequest, _ := authorized.(map[string]interface{})
+	if request.Essential {
+		if essential, _ := authorizedRequest["essential"].(bool); !essential {
+			return false
+		}
+	}
+
+	var authorizedValues []interface{}
+	if value, ok := authorizedRequest["value"]; ok {
+		authorizedValues = append(authorizedValues, value)
+	}
+	if values, ok := authorizedRequest["values"].([]interface{}); ok {
+		authorizedValues = append(authorizedValues, values...)
+	}
+
+	if request.Value != nil {
+		if len(authorizedValues) == 0 {
+			return false
+		}
+		for _, value := range authorizedValues {
+			if !claimValuesEqual(value, request.Value) {
+				return false
+			}
+		}
+	}
+	if request.Values != nil {
+		if len(authorizedValues) == 0 {
+			return false
+		}
+		for _, value := range authorizedValues {
+			found := false
+			for _, v := range request.Values {
+				if claimValuesEqual(value, v) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+
+	return true
+}
@@ -6,9 +6,22 @@
 package kcoidc
 
 import (
+	"strings"
+
 	"github.com/dgrijalva/jwt-go"
 )
 
+// Standard token claims which are split from the extra claims.
+const (
+	AudienceClaim  = "aud"
+	ExpiresAtClaim = "exp"
+	IDClaim        = "jti"
+	IssuedAtClaim  = "iat"
+	IssuerClaim    = "iss"
+	NotBeforeClaim = "nbf"
+	SubjectClaim   = "sub"
+)
+
 // Token claims used by Kopano Konnect.
 const (
 	IsAccessTokenClaim  = "kc.isAccessToken"
@@ -22,11 +35,26 @@ const (
 	AuthorizedClaimsClaim = "kc.authorizedClaims"
 )
 
+// Token claims used by JWT access tokens as defined in RFC 9068.
+const (
+	ScopeClaim    = "scope"
+	ClientIDClaim = "client_id"
+)
+
+// Token claims used by sender constrained tokens as defined in RFC 7800, RFC
+// 8705 and RFC 9449.
+const (
+	ConfirmationClaim                          = "cnf"
+	JWKThumbprintConfirmationClaim             = "jkt"
+	X509CertificateThumbprintConfirmationClaim = "x5t#S256"
+)
+
 // Token types as int.
 const (
 	TokenTypeStandard  int = 0
 	TokenTypeKCAccess  int = 1
 	TokenTypeKCRefresh int = 2
+	TokenTypeAccess    int = 3
 )
 
 // ExtraClaimsWithType is a MapClaims with a specific type.
@@ -37,7 +65,44 @@ func (claims *ExtraClaimsWithType) Valid() error {
 	return nil
 }
 
-// KCTokenType returns the numeric type of the accociated claims.
+// Int64Claim returns the integer value found at the provided dot separated
+// claim path of the accociated claims. Numbers which are not integers or do not
+// fit into int64 are not returned.
+func (claims *ExtraClaimsWithType) Int64Claim(claimPath string) (int64, bool) {
+	value, ok := valueFromMapPath(*claims, claimPath)
+	if !ok {
+		return 0, false
+	}
+
+	return int64FromValue(value)
+}
+
+// Float64Claim returns the numeric value found at the provided dot separated
+// claim path of the accociated claims as float64.
+func (claims *ExtraClaimsWithType) Float64Claim(claimPath string) (float64, bool) {
+	value, ok := valueFromMapPath(*claims, claimPath)
+	if !ok {
+		return 0, false
+	}
+
+	return numberFromValue(value)
+}
+
+// StringClaim returns the string value found at the provided dot separated
+// claim path of the accociated claims. Numbers are returned with their exact
+// original representation.
+func (claims *ExtraClaimsWithType) StringClaim(claimPath string) (string, bool) {
+	value, ok := valueFromMapPath(*claims, claimPath)
+	if !ok {
+		return "", false
+	}
+
+	return stringFromValue(value)
+}
+
+// KCTokenType returns the numeric type of the accociated claims. JWT access
+// tokens as defined in RFC 9068 are marked by their header and thus cannot be
+// detected from the claims.
 func (claims *ExtraClaimsWithType) KCTokenType() int {
 	if v, _ := (*claims)[IsAccessTokenClaim].(bool); v {
 		return TokenTypeKCAccess
@@ -49,17 +114,27 @@ func (claims *ExtraClaimsWithType) KCTokenType() int {
 	return TokenTypeStandard
 }
 
+func isTokenTypeAllowed(tokenType int, allowedTokenTypes []int) bool {
+	for _, allowedTokenType := range allowedTokenTypes {
+		if tokenType == allowedTokenType {
+			return true
+		}
+	}
+
+	return false
+}
+
 // SplitStandardClaimsFromMapClaims removes all JWT standard claims from the
 // provided map claims and returns them.
 func SplitStandardClaimsFromMapClaims(claims *ExtraClaimsWithType) (*jwt.StandardClaims, error) {
 	std := &jwt.StandardClaims{
-		Audience:  popStringFromMap(*claims, "aud"),
-		ExpiresAt: popInt64FromMap(*claims, "exp"),
-		Id:        popStringFromMap(*claims, "jti"),
-		IssuedAt:  popInt64FromMap(*claims, "iat"),
-		Issuer:    popStringFromMap(*claims, "iss"),
-		NotBefore: popInt64FromMap(*claims, "nbf"),
-		Subject:   popStringFromMap(*claims, "sub"),
+		Audience:  popStringFromMap(*claims, AudienceClaim),
+		ExpiresAt: popInt64FromMap(*claims, ExpiresAtClaim),
+		Id:        popStringFromMap(*claims, IDClaim),
+		IssuedAt:  popInt64FromMap(*claims, IssuedAtClaim),
+		Issuer:    popStringFromMap(*claims, IssuerClaim),
+		NotBefore: popInt64FromMap(*claims, NotBeforeClaim),
+		Subject:   popStringFromMap(*claims, SubjectClaim),
 	}
 
 	return std, nil
@@ -77,6 +152,33 @@ func AuthenticatedUserIDFromClaims(claims *ExtraClaimsWithType) (string, bool) {
 	return "", false
 }
 
+// AuthenticatedUserIDFromClaimPaths returns the authenticated user id from the
+// first of the provided claim paths which is found in the provided claims,
+// together with the matching claim path. Claim paths are dot separated, to
+// reach into nested claims. The standard Subject is found by its claim name.
+func AuthenticatedUserIDFromClaimPaths(claimPaths []string, standardClaims *jwt.StandardClaims, claims *ExtraClaimsWithType) (string, string, bool) {
+	for _, claimPath := range claimPaths {
+		var value interface{}
+		var ok bool
+		switch claimPath {
+		case SubjectClaim:
+			if standardClaims != nil {
+				value, ok = standardClaims.Subject, true
+			}
+		default:
+			value, ok = valueFromMapPath(*claims, claimPath)
+		}
+		if !ok {
+			continue
+		}
+		if authenticatedUserID, ok := stringFromValue(value); ok {
+			return authenticatedUserID, claimPath, true
+		}
+	}
+
+	return "", "", false
+}
+
 // AuthenticatedUserIsGuest extract extra Kopano Connect identified claims from
 // the provided extra claims, returning if the claims are for a guest or not.
 func AuthenticatedUserIsGuest(claims *ExtraClaimsWithType) bool {
@@ -89,7 +191,8 @@ func AuthenticatedUserIsGuest(claims *ExtraClaimsWithType) bool {
 }
 
 // AuthorizedScopesFromClaims returns the authorized scopes as bool map from
-// the provided extra claims.
+// the provided extra claims. If the Kopano Konnect authorized scopes claim is
+// not found, the space separated scope claim as defined in RFC 9068 is used.
 func AuthorizedScopesFromClaims(claims *ExtraClaimsWithType) map[string]bool {
 	if authorizedScopes, _ := (*claims)[AuthorizedScopesClaim].([]interface{}); authorizedScopes != nil {
 		authorizedScopesMap := make(map[string]bool)
@@ -100,6 +203,19 @@ func AuthorizedScopesFromClaims(claims *ExtraClaimsWithType) map[string]bool {
 		return authorizedScopesMap
 	}
 
+	return scopesFromSpaceSeparatedClaim(claims, ScopeClaim)
+}
+
+func scopesFromSpaceSeparatedClaim(claims *ExtraClaimsWithType, claim string) map[string]bool {
+	if scopeValue, _ := (*claims)[claim].(string); scopeValue != "" {
+		scopesMap := make(map[string]bool)
+		for _, scope := range strings.Fields(scopeValue) {
+			scopesMap[scope] = true
+		}
+
+		return scopesMap
+	}
+
 	return nil
 }
 
@@ -111,23 +227,79 @@ func AuthorizedClaimsFromClaims(claims *ExtraClaimsWithType) map[string]interfac
 	return authorizedClaims
 }
 
+// Scope expression syntax as supported by RequireScopesInClaims.
+const (
+	ScopeAnyOfSeparator = "|"
+	ScopeWildcardSuffix = "*"
+)
+
 // RequireScopesInClaims returns nil if all the provided scopes are found in
-// the provided claims. Otherwise an error is returned.
+// the provided claims. Otherwise an error is returned. Each required scope can
+// list alternatives separated by ScopeAnyOfSeparator of which any must be
+// found, and can end with ScopeWildcardSuffix to match all scopes with the
+// given prefix. For compatibility, the returned error is always
+// ErrStatusMissingRequiredScope, use RequireScopesInClaimsWithProfile to get
+// the missing scopes.
 func RequireScopesInClaims(claims *ExtraClaimsWithType, requiredScopes []string) error {
+	if err := RequireScopesInClaimsWithProfile(KonnectClaimsProfile, claims, requiredScopes); err != nil {
+		return ErrStatusMissingRequiredScope
+	}
+
+	return nil
+}
+
+// RequireScopesInClaimsWithProfile returns nil if all the provided scopes are
+// found in the provided claims, using the provided claims profile to derive the
+// authorized scopes. Otherwise a *MissingScopesError is returned, which matches
+// ErrStatusMissingRequiredScope with errors.Is. See RequireScopesInClaims for
+// the supported syntax.
+func RequireScopesInClaimsWithProfile(profile ClaimsProfile, claims *ExtraClaimsWithType, requiredScopes []string) error {
 	if len(requiredScopes) == 0 {
 		return nil
 	}
 
-	authorizedScopes := AuthorizedScopesFromClaims(claims)
+	missingScopes := MissingScopes(profile.AuthorizedScopes(claims), requiredScopes)
+	if len(missingScopes) == 0 {
+		return nil
+	}
+
+	return &MissingScopesError{
+		Scopes: missingScopes,
+	}
+}
+
+// MissingScopes returns the provided required scopes which are not satisfied
+// by the provided authorized scopes. See RequireScopesInClaims for the
+// supported syntax.
+func MissingScopes(authorizedScopes map[string]bool, requiredScopes []string) []string {
 	missingScopes := make([]string, 0)
-	for _, scope := range requiredScopes {
-		if ok, _ := authorizedScopes[scope]; !ok {
-			missingScopes = append(missingScopes, scope)
+	for _, requiredScope := range requiredScopes {
+		found := false
+		for _, scope := range strings.Split(requiredScope, ScopeAnyOfSeparator) {
+			if isScopeAuthorized(authorizedScopes, scope) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missingScopes = append(missingScopes, requiredScope)
 		}
 	}
-	if len(missingScopes) == 0 {
-		return nil
+
+	return missingScopes
+}
+
+func isScopeAuthorized(authorizedScopes map[string]bool, scope string) bool {
+	if !strings.HasSuffix(scope, ScopeWildcardSuffix) {
+		return authorizedScopes[scope]
 	}
 
-	return ErrStatusMissingRequiredScope
+	prefix := strings.TrimSuffix(scope, ScopeWildcardSuffix)
+	for authorizedScope, ok := range authorizedScopes {
+		if ok && strings.HasPrefix(authorizedScope, prefix) {
+			return true
+		}
+	}
+
+	return false
 }
@@ -0,0 +1,158 @@
+/*
+ * SPDX-License-Identifier: AGPL-3.0-or-later
+ * Copyright 2020 Kopano and its licensors
+ */
+
+package kcoidc
+
+import (
+	"strings"
+)
+
+// A ClaimsProfile defines how the authenticated user ID, the guest flag, the
+// authorized scopes and the token type are derived from the claims of tokens
+// issued by a specific kind of OP.
+type ClaimsProfile interface {
+	// Name returns the name of the profile.
+	Name() string
+	// UserIDClaimPaths returns the ordered claim paths which are used to
+	// derive the authenticated user ID. See SetUserIDClaimPaths of Provider.
+	UserIDClaimPaths() []string
+	// IsGuest returns true if the provided extra claims are for a guest.
+	IsGuest(claims *ExtraClaimsWithType) bool
+	// AuthorizedScopes returns the authorized scopes as bool map from the
+	// provided extra claims.
+	AuthorizedScopes(claims *ExtraClaimsWithType) map[string]bool
+	// TokenType returns the numeric token type of the provided extra claims.
+	TokenType(claims *ExtraClaimsWithType) int
+}
+
+// Names of the built-in claims profiles.
+const (
+	ClaimsProfileNameKonnect  = "konnect"
+	ClaimsProfileNameGeneric  = "generic"
+	ClaimsProfileNameKeycloak = "keycloak"
+)
+
+// Built-in claims profiles.
+var (
+	// KonnectClaimsProfile derives values from the claims set by Kopano
+	// Konnect. This is the default.
+	KonnectClaimsProfile ClaimsProfile = &konnectClaimsProfile{}
+	// GenericClaimsProfile derives values from standard OpenID Connect and
+	// RFC 9068 claims only.
+	GenericClaimsProfile ClaimsProfile = &genericClaimsProfile{}
+	// KeycloakClaimsProfile derives values from the claims set by Keycloak.
+	KeycloakClaimsProfile ClaimsProfile = &keycloakClaimsProfile{}
+)
+
+// DefaultClaimsProfile is the claims profile used by a Provider if not
+// explicitly configured otherwise.
+var DefaultClaimsProfile = KonnectClaimsProfile
+
+var claimsProfiles = map[string]ClaimsProfile{
+	ClaimsProfileNameKonnect:  KonnectClaimsProfile,
+	ClaimsProfileNameGeneric:  GenericClaimsProfile,
+	ClaimsProfileNameKeycloak: KeycloakClaimsProfile,
+}
+
+// ClaimsProfileByName returns the built-in claims profile with the provided
+// name.
+func ClaimsProfileByName(name string) (ClaimsProfile, error) {
+	profile, ok := claimsProfiles[strings.ToLower(name)]
+	if !ok {
+		return nil, ErrStatusUnknownClaimsProfile
+	}
+
+	return profile, nil
+}
+
+type konnectClaimsProfile struct{}
+
+func (profile *konnectClaimsProfile) Name() string {
+	return ClaimsProfileNameKonnect
+}
+
+func (profile *konnectClaimsProfile) UserIDClaimPaths() []string {
+	// NOTE(longsleep): Fallback to standard Subject if no extra information
+	// is set in token. This can happen for older Konnect installations
+	// which did not set this claim. Let's do this for compatibility.
+	return []string{IdentityClaim + "." + IdentifiedUserIDClaim, SubjectClaim}
+}
+
+func (profile *konnectClaimsProfile) IsGuest(claims *ExtraClaimsWithType) bool {
+	return AuthenticatedUserIsGuest(claims)
+}
+
+func (profile *konnectClaimsProfile) AuthorizedScopes(claims *ExtraClaimsWithType) map[string]bool {
+	return AuthorizedScopesFromClaims(claims)
+}
+
+func (profile *konnectClaimsProfile) TokenType(claims *ExtraClaimsWithType) int {
+	return claims.KCTokenType()
+}
+
+type genericClaimsProfile struct{}
+
+func (profile *genericClaimsProfile) Name() string {
+	return ClaimsProfileNameGeneric
+}
+
+func (profile *genericClaimsProfile) UserIDClaimPaths() []string {
+	return []string{SubjectClaim}
+}
+
+func (profile *genericClaimsProfile) IsGuest(claims *ExtraClaimsWithType) bool {
+	return false
+}
+
+func (profile *genericClaimsProfile) AuthorizedScopes(claims *ExtraClaimsWithType) map[string]bool {
+	return scopesFromSpaceSeparatedClaim(claims, ScopeClaim)
+}
+
+func (profile *genericClaimsProfile) TokenType(claims *ExtraClaimsWithType) int {
+	// NOTE: JWT access tokens as defined in RFC 9068 are marked by their
+	// header, which is handled by the Provider for all profiles.
+	return TokenTypeStandard
+}
+
+// Token claims and values used by Keycloak.
+const (
+	KeycloakTypeClaim = "typ"
+
+	KeycloakTypeBearer  = "Bearer"
+	KeycloakTypeRefresh = "Refresh"
+	KeycloakTypeOffline = "Offline"
+)
+
+type keycloakClaimsProfile struct{}
+
+func (profile *keycloakClaimsProfile) Name() string {
+	return ClaimsProfileNameKeycloak
+}
+
+func (profile *keycloakClaimsProfile) UserIDClaimPaths() []string {
+	return []string{SubjectClaim}
+}
+
+func (profile *keycloakClaimsProfile) IsGuest(claims *ExtraClaimsWithType) bool {
+	return false
+}
+
+func (profile *keycloakClaimsProfile) AuthorizedScopes(claims *ExtraClaimsWithType) map[string]bool {
+	return scopesFromSpaceSeparatedClaim(claims, ScopeClaim)
+}
+
+func (profile *keycloakClaimsProfile) TokenType(claims *ExtraClaimsWithType) int {
+	typ, _ := (*claims)[KeycloakTypeClaim].(string)
+	switch typ {
+	case KeycloakTypeBearer:
+		return TokenTypeAccess
+	case KeycloakTypeRefresh, KeycloakTypeOffline:
+		// NOTE: Map to the refresh token type, so such tokens are subject to
+		// the same token type policy as Konnect refresh tokens.
+		return TokenTypeKCRefresh
+	}
+
+	return TokenTypeStandard
+}
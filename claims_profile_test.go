@@ -0,0 +1,90 @@
+/*
+ * SPDX-License-Identifier: AGPL-3.0-or-later
+ * Copyright 2020 Kopano and its licensors
+ */
+
+package kcoidc
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestClaimsProfileByName(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		profile ClaimsProfile
+		err     error
+	}{
+		{"konnect", KonnectClaimsProfile, nil},
+		{"Generic", GenericClaimsProfile, nil},
+		{"KEYCLOAK", KeycloakClaimsProfile, nil},
+		{"unknown", nil, ErrStatusUnknownClaimsProfile},
+	} {
+		profile, err := ClaimsProfileByName(tc.name)
+		if profile != tc.profile || err != tc.err {
+			t.Errorf("unexpected result for %s: got %v, %v", tc.name, profile, err)
+		}
+		if profile != nil && profile.Name() != strings.ToLower(tc.name) {
+			t.Errorf("unexpected name for %s: %v", tc.name, profile.Name())
+		}
+	}
+}
+
+func TestClaimsProfiles(t *testing.T) {
+	standardClaims := &jwt.StandardClaims{
+		Subject: "sub1",
+	}
+	konnectClaims := &ExtraClaimsWithType{
+		IsAccessTokenClaim:    true,
+		AuthorizedScopesClaim: []interface{}{"openid", "kopano/gc"},
+		ScopeClaim:            "openid profile",
+		IdentityClaim: map[string]interface{}{
+			IdentifiedUserIDClaim: "id1",
+			IdentifiedUserIsGuest: true,
+		},
+	}
+	genericClaims := &ExtraClaimsWithType{
+		ClientIDClaim: "client1",
+		ScopeClaim:    "openid profile",
+	}
+	keycloakClaims := &ExtraClaimsWithType{
+		KeycloakTypeClaim: KeycloakTypeBearer,
+		ScopeClaim:        "openid email",
+	}
+
+	for _, tc := range []struct {
+		profile          ClaimsProfile
+		claims           *ExtraClaimsWithType
+		userID           string
+		isGuest          bool
+		authorizedScopes map[string]bool
+		tokenType        int
+	}{
+		{KonnectClaimsProfile, konnectClaims, "id1", true, map[string]bool{"openid": true, "kopano/gc": true}, TokenTypeKCAccess},
+		{KonnectClaimsProfile, genericClaims, "sub1", false, map[string]bool{"openid": true, "profile": true}, TokenTypeStandard},
+		{GenericClaimsProfile, konnectClaims, "sub1", false, map[string]bool{"openid": true, "profile": true}, TokenTypeStandard},
+		{GenericClaimsProfile, genericClaims, "sub1", false, map[string]bool{"openid": true, "profile": true}, TokenTypeStandard},
+		{KeycloakClaimsProfile, keycloakClaims, "sub1", false, map[string]bool{"openid": true, "email": true}, TokenTypeAccess},
+		{KeycloakClaimsProfile, &ExtraClaimsWithType{KeycloakTypeClaim: KeycloakTypeRefresh}, "sub1", false, nil, TokenTypeKCRefresh},
+		{KeycloakClaimsProfile, &ExtraClaimsWithType{KeycloakTypeClaim: KeycloakTypeOffline}, "sub1", false, nil, TokenTypeKCRefresh},
+		{KeycloakClaimsProfile, &ExtraClaimsWithType{KeycloakTypeClaim: "ID"}, "sub1", false, nil, TokenTypeStandard},
+	} {
+		userID, _, ok := AuthenticatedUserIDFromClaimPaths(tc.profile.UserIDClaimPaths(), standardClaims, tc.claims)
+		if !ok || userID != tc.userID {
+			t.Errorf("unexpected user ID for %s %v: got %v", tc.profile.Name(), *tc.claims, userID)
+		}
+		if isGuest := tc.profile.IsGuest(tc.claims); isGuest != tc.isGuest {
+			t.Errorf("unexpected guest flag for %s %v: got %v", tc.profile.Name(), *tc.claims, isGuest)
+		}
+		if authorizedScopes := tc.profile.AuthorizedScopes(tc.claims); !reflect.DeepEqual(authorizedScopes, tc.authorizedScopes) {
+			t.Errorf("unexpected authorized scopes for %s %v: got %v", tc.profile.Name(), *tc.claims, authorizedScopes)
+		}
+		if tokenType := tc.profile.TokenType(tc.claims); tokenType != tc.tokenType {
+			t.Errorf("unexpected token type for %s %v: got %d, want %d", tc.profile.Name(), *tc.claims, tokenType, tc.tokenType)
+		}
+	}
+}
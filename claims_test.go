@@ -0,0 +1,273 @@
+/*
+ * SPDX-License-Identifier: AGPL-3.0-or-later
+ * Copyright 2020 Kopano and its licensors
+ */
+
+package kcoidc
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestKCTokenType(t *testing.T) {
+	for _, tc := range []struct {
+		claims    ExtraClaimsWithType
+		tokenType int
+	}{
+		{ExtraClaimsWithType{}, TokenTypeStandard},
+		{ExtraClaimsWithType{IsAccessTokenClaim: true}, TokenTypeKCAccess},
+		{ExtraClaimsWithType{IsRefreshTokenClaim: true}, TokenTypeKCRefresh},
+		{ExtraClaimsWithType{ClientIDClaim: "client"}, TokenTypeStandard},
+	} {
+		if tokenType := tc.claims.KCTokenType(); tokenType != tc.tokenType {
+			t.Errorf("unexpected token type for %v: got %d, want %d", tc.claims, tokenType, tc.tokenType)
+		}
+	}
+}
+
+func TestRequireScopesInClaimsRFC9068(t *testing.T) {
+	claims := &ExtraClaimsWithType{
+		ScopeClaim: "openid  profile email",
+	}
+
+	if err := RequireScopesInClaims(claims, []string{"openid", "email"}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := RequireScopesInClaims(claims, []string{"kopano/gc"}); !errors.Is(err, ErrStatusMissingRequiredScope) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestValidateJWTAccessToken(t *testing.T) {
+	standardClaims := &jwt.StandardClaims{
+		Issuer:    "https://issuer.example",
+		Subject:   "user1",
+		ExpiresAt: 2,
+		IssuedAt:  1,
+		Id:        "jti",
+	}
+	claims := &ExtraClaimsWithType{
+		ClientIDClaim: "client",
+	}
+	atHeader := map[string]interface{}{"typ": "at+jwt"}
+	jwtHeader := map[string]interface{}{"typ": "JWT"}
+	audience := []interface{}{"api1", "api2"}
+
+	if err := validateJWTAccessToken(atHeader, audience, standardClaims, claims, true); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := validateJWTAccessToken(atHeader, "api1", standardClaims, claims, true); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := validateJWTAccessToken(jwtHeader, nil, standardClaims, claims, false); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := validateJWTAccessToken(jwtHeader, audience, standardClaims, claims, true); err != ErrStatusTokenUnexpectedType {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := validateJWTAccessToken(atHeader, audience, standardClaims, &ExtraClaimsWithType{}, false); err != ErrStatusTokenValidationFailed {
+		t.Errorf("unexpected error: %v", err)
+	}
+	for _, invalidAudience := range []interface{}{nil, "", []interface{}{}, []interface{}{""}} {
+		if err := validateJWTAccessToken(atHeader, invalidAudience, standardClaims, claims, false); err != ErrStatusTokenValidationFailed {
+			t.Errorf("unexpected error for audience %#v: %v", invalidAudience, err)
+		}
+	}
+}
+
+func TestTokenTypeWithHeader(t *testing.T) {
+	atHeader := map[string]interface{}{"typ": "at+jwt"}
+	jwtHeader := map[string]interface{}{"typ": "JWT"}
+
+	for _, tc := range []struct {
+		header    map[string]interface{}
+		claims    ExtraClaimsWithType
+		tokenType int
+	}{
+		{jwtHeader, ExtraClaimsWithType{}, TokenTypeStandard},
+		{jwtHeader, ExtraClaimsWithType{ClientIDClaim: "client"}, TokenTypeStandard},
+		{atHeader, ExtraClaimsWithType{ClientIDClaim: "client"}, TokenTypeAccess},
+		{atHeader, ExtraClaimsWithType{IsAccessTokenClaim: true}, TokenTypeKCAccess},
+		{nil, ExtraClaimsWithType{IsRefreshTokenClaim: true}, TokenTypeKCRefresh},
+	} {
+		if tokenType := tokenTypeWithHeader(KonnectClaimsProfile, tc.header, &tc.claims); tokenType != tc.tokenType {
+			t.Errorf("unexpected token type for %v %v: got %d, want %d", tc.header, tc.claims, tokenType, tc.tokenType)
+		}
+	}
+}
+
+func TestAuthenticatedUserIDFromClaimPaths(t *testing.T) {
+	standardClaims := &jwt.StandardClaims{
+		Subject: "sub1",
+	}
+	claims := &ExtraClaimsWithType{
+		IdentityClaim: map[string]interface{}{
+			IdentifiedUserIDClaim: "id1",
+		},
+		"preferred_username": "user1",
+	}
+
+	for _, tc := range []struct {
+		claimPaths []string
+		userID     string
+		claimPath  string
+		ok         bool
+	}{
+		{[]string{"kc.identity.kc.i.id", "sub"}, "id1", "kc.identity.kc.i.id", true},
+		{[]string{"email", "preferred_username"}, "user1", "preferred_username", true},
+		{[]string{"kc.identity.kc.i.un", "sub"}, "sub1", "sub", true},
+		{[]string{"email"}, "", "", false},
+	} {
+		userID, claimPath, ok := AuthenticatedUserIDFromClaimPaths(tc.claimPaths, standardClaims, claims)
+		if userID != tc.userID || claimPath != tc.claimPath || ok != tc.ok {
+			t.Errorf("unexpected result for %v: got %v %v %v", tc.claimPaths, userID, claimPath, ok)
+		}
+	}
+}
+
+func TestRequireScopesInClaims(t *testing.T) {
+	claims := &ExtraClaimsWithType{
+		AuthorizedScopesClaim: []interface{}{"openid", "kopano/gc", "kopano/kvs"},
+	}
+
+	for _, tc := range []struct {
+		requiredScopes []string
+		missingScopes  []string
+	}{
+		{[]string{"openid", "kopano/gc"}, nil},
+		{[]string{"kopano/*"}, nil},
+		{[]string{"kopano/gc*", "profile|kopano/kvs"}, nil},
+		{[]string{"openid", "profile", "kopano/kwm*"}, []string{"profile", "kopano/kwm*"}},
+		{[]string{"profile|email"}, []string{"profile|email"}},
+	} {
+		err := RequireScopesInClaims(claims, tc.requiredScopes)
+		if tc.missingScopes == nil {
+			if err != nil {
+				t.Errorf("unexpected error for %v: %v", tc.requiredScopes, err)
+			}
+			continue
+		}
+		if err != ErrStatusMissingRequiredScope {
+			t.Errorf("unexpected error for %v: %v", tc.requiredScopes, err)
+		}
+		err = RequireScopesInClaimsWithProfile(KonnectClaimsProfile, claims, tc.requiredScopes)
+		if !errors.Is(err, ErrStatusMissingRequiredScope) {
+			t.Errorf("unexpected error with profile for %v: %v", tc.requiredScopes, err)
+		}
+		var missingScopesErr *MissingScopesError
+		if !errors.As(err, &missingScopesErr) {
+			t.Errorf("unexpected error for %v: %v", tc.requiredScopes, err)
+			continue
+		}
+		if !reflect.DeepEqual(missingScopesErr.Scopes, tc.missingScopes) {
+			t.Errorf("unexpected missing scopes for %v: got %v, want %v", tc.requiredScopes, missingScopesErr.Scopes, tc.missingScopes)
+		}
+	}
+}
+
+func TestRequireAuthorizedClaims(t *testing.T) {
+	claims := &ExtraClaimsWithType{
+		AuthorizedClaimsClaim: map[string]interface{}{
+			ClaimsRequestIDToken: map[string]interface{}{
+				"email": map[string]interface{}{"essential": true},
+				"acr":   map[string]interface{}{"values": []interface{}{"1", "2"}},
+			},
+		},
+	}
+
+	requiredClaims, err := ParseClaimsRequest([]byte(`{"id_token":{"email":{"essential":true},"acr":{"values":["1","2","3"]}}}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = RequireAuthorizedClaims(claims, requiredClaims); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	requiredClaims, _ = ParseClaimsRequest([]byte(`{"id_token":{"acr":{"value":"3"}},"userinfo":{"name":null}}`))
+	err = RequireAuthorizedClaims(claims, requiredClaims)
+	var missingClaimsErr *MissingClaimsError
+	if !errors.As(err, &missingClaimsErr) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []string{"id_token.acr", "userinfo.name"}; !reflect.DeepEqual(missingClaimsErr.Claims, want) {
+		t.Errorf("unexpected missing claims: got %v, want %v", missingClaimsErr.Claims, want)
+	}
+}
+
+func TestKonnectIdentityFromClaims(t *testing.T) {
+	claims := &ExtraClaimsWithType{
+		IdentityClaim: map[string]interface{}{
+			IdentifiedUserIDClaim:      "id1",
+			IdentifiedUserIsGuest:      true,
+			IdentifiedUsernameClaim:    "user1",
+			IdentifiedDisplayNameClaim: "User One",
+			"kc.i.us":                  "sub1",
+		},
+		EmailClaim: "user1@example.com",
+	}
+
+	identity, err := KonnectIdentityFromClaims(claims)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := &KonnectIdentity{
+		UserID:      "id1",
+		IsGuest:     true,
+		Username:    "user1",
+		DisplayName: "User One",
+		Email:       "user1@example.com",
+		Claims:      map[string]interface{}{"kc.i.us": "sub1"},
+	}
+	if !reflect.DeepEqual(identity, expected) {
+		t.Errorf("unexpected identity: got %#v, want %#v", identity, expected)
+	}
+
+	if _, err = KonnectIdentityFromClaims(&ExtraClaimsWithType{}); err != ErrStatusMissingIdentityClaim {
+		t.Errorf("unexpected error: %v", err)
+	}
+	claims = &ExtraClaimsWithType{
+		IdentityClaim: map[string]interface{}{
+			IdentifiedUserIDClaim: "id1",
+			IdentifiedUserIsGuest: "yes",
+		},
+	}
+	if _, err = KonnectIdentityFromClaims(claims); !errors.Is(err, ErrStatusInvalidIdentityClaim) {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestNumericClaimsPrecision(t *testing.T) {
+	parser := &jwt.Parser{UseJSONNumber: true}
+	claims := &ExtraClaimsWithType{}
+	// {"exp":1600000000,"uid":9007199254740993,"ratio":0.5}
+	_, _, err := parser.ParseUnverified("eyJhbGciOiJub25lIn0.eyJleHAiOjE2MDAwMDAwMDAsInVpZCI6OTAwNzE5OTI1NDc0MDk5MywicmF0aW8iOjAuNX0.", claims)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if uid, ok := claims.Int64Claim("uid"); !ok || uid != 9007199254740993 {
+		t.Errorf("unexpected uid: %v %v", uid, ok)
+	}
+	if uid, ok := claims.StringClaim("uid"); !ok || uid != "9007199254740993" {
+		t.Errorf("unexpected uid string: %v %v", uid, ok)
+	}
+	if _, ok := claims.Int64Claim("ratio"); ok {
+		t.Errorf("unexpected int64 for non integer claim")
+	}
+	if ratio, ok := claims.Float64Claim("ratio"); !ok || ratio != 0.5 {
+		t.Errorf("unexpected ratio: %v %v", ratio, ok)
+	}
+	if claimValuesEqual((*claims)["uid"], json.Number("9007199254740992")) {
+		t.Errorf("unexpected equal for different large integers")
+	}
+
+	standardClaims, _ := SplitStandardClaimsFromMapClaims(claims)
+	if standardClaims.ExpiresAt != 1600000000 {
+		t.Errorf("unexpected exp: %v", standardClaims.ExpiresAt)
+	}
+}
@@ -63,14 +63,20 @@ func run(issString, tokenString string) error {
 	}
 
 	beginTime := time.Now()
-	authenticatedUserID, standardClaims, extraClaims, err := provider.ValidateTokenString(ctx, tokenString)
+	result, err := provider.ValidateToken(ctx, tokenString)
 	endTime := time.Now()
+	authenticatedUserID, standardClaims, extraClaims := result.AuthenticatedUserID, result.StandardClaims, result.ExtraClaims
 	duration := endTime.Sub(beginTime)
 
 	validString := "valid"
 	if err != nil {
 		validString = "invalid"
 	}
+	// Claims are nil when validation failed before the token was parsed.
+	var subject string
+	if standardClaims != nil {
+		subject = standardClaims.Subject
+	}
 
 	if e := printResultOrError(err, "Result code"); e != nil {
 		fmt.Printf("> Error: failed to validate token string: %v\n", e)
@@ -78,13 +84,15 @@ func run(issString, tokenString string) error {
 
 	fmt.Printf("> Validation    : %s\n", validString)
 	fmt.Printf("> Auth ID       : %s\n", authenticatedUserID)
-	fmt.Printf("> Subject       : %s\n", standardClaims.Subject)
+	fmt.Printf("> Subject       : %s\n", subject)
 	fmt.Printf("> Time spent    : %fs\n", duration.Seconds())
+	fmt.Printf("> Header        : %v\n", result.Header)
+	fmt.Printf("> Claims        : %v\n", result.Claims)
 	fmt.Printf("> Standard      : %v\n", standardClaims)
 	fmt.Printf("> Extra         : %v\n", extraClaims)
-	fmt.Printf("> Token type    : %d\n", extraClaims.KCTokenType())
+	fmt.Printf("> Token type    : %d\n", result.TokenType)
 
-	if err == nil && extraClaims.KCTokenType() == kcoidc.TokenTypeKCAccess {
+	if err == nil && (result.TokenType == kcoidc.TokenTypeKCAccess || result.TokenType == kcoidc.TokenTypeAccess) {
 		userinfo, userinfoErr := provider.FetchUserinfoWithAccesstokenString(ctx, tokenString)
 
 		if e := printResultOrError(userinfoErr, "Userinfo   "); e != nil {
@@ -0,0 +1,250 @@
+/*
+ * SPDX-License-Identifier: AGPL-3.0-or-later
+ * Copyright 2020 Kopano and its licensors
+ */
+
+package kcoidc
+
+import (
+	"container/heap"
+	"context"
+	"crypto"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// DPoP values as defined in RFC 9449.
+const (
+	DPoPProofType = "dpop+jwt"
+
+	DPoPHTTPMethodClaim      = "htm"
+	DPoPHTTPURIClaim         = "htu"
+	DPoPAccessTokenHashClaim = "ath"
+)
+
+// DefaultDPoPProofMaxAge is the maximum age of DPoP proofs which is accepted
+// by a Provider if not explicitly configured otherwise.
+var DefaultDPoPProofMaxAge = 5 * time.Minute
+
+// DefaultDPoPReplayCacheSize is the maximum number of DPoP proof IDs which are
+// remembered by a Provider to detect replayed proofs. Once full, the IDs which
+// expire first are dropped.
+var DefaultDPoPReplayCacheSize = 100000
+
+// ValidateDPoPTokenString validates the provided DPoP bound access token
+// string value like ValidateTokenString and in addition validates the provided
+// DPoP proof as defined in RFC 9449 for the provided HTTP method and URI. The
+// proof must be signed by the key its header contains, must match the HTTP
+// request and the access token and must not be replayed. The access token must
+// be bound to the key of the proof with its cnf.jkt claim.
+func (p *Provider) ValidateDPoPTokenString(ctx context.Context, tokenString string, proofString string, method string, uri string, opts ...ValidateOption) (string, *jwt.StandardClaims, *ExtraClaimsWithType, error) {
+	result, err := p.ValidateDPoPToken(ctx, tokenString, proofString, method, uri, opts...)
+
+	return result.AuthenticatedUserID, result.StandardClaims, result.ExtraClaims, err
+}
+
+// ValidateDPoPToken validates the provided DPoP bound access token string value
+// and DPoP proof like ValidateDPoPTokenString and returns a ValidationResult
+// like ValidateToken.
+func (p *Provider) ValidateDPoPToken(ctx context.Context, tokenString string, proofString string, method string, uri string, opts ...ValidateOption) (*ValidationResult, error) {
+	result, err := p.ValidateToken(ctx, tokenString, opts...)
+	if err != nil {
+		return result, err
+	}
+
+	p.mutex.RLock()
+	maxAge := p.dpopProofMaxAge
+	p.mutex.RUnlock()
+
+	thumbprint, err := p.validateDPoPProof(proofString, tokenString, method, uri, maxAge)
+	if err == nil {
+		jkt, _ := valueFromMapPath(*result.ExtraClaims, ConfirmationClaim+"."+JWKThumbprintConfirmationClaim)
+		if jkt != thumbprint {
+			err = ErrStatusDPoPBindingMismatch
+		}
+	}
+
+	return result, err
+}
+
+// validateDPoPProof validates the provided DPoP proof and returns the base64url
+// encoded SHA-256 JWK thumbprint of its key.
+func (p *Provider) validateDPoPProof(proofString string, tokenString string, method string, uri string, maxAge time.Duration) (string, error) {
+	var proofKey *jose.JSONWebKey
+	claims := &ExtraClaimsWithType{}
+	proof, err := p.parser.ParseWithClaims(proofString, claims, func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); !strings.EqualFold(typ, DPoPProofType) {
+			return nil, fmt.Errorf("unexpected typ %v", token.Header["typ"])
+		}
+		if isSigningMethodForbidden(token.Method) {
+			return nil, fmt.Errorf("unexpected alg %s", token.Method.Alg())
+		}
+
+		jwk, err := json.Marshal(token.Header["jwk"])
+		if err != nil {
+			return nil, err
+		}
+		proofKey = &jose.JSONWebKey{}
+		if err = proofKey.UnmarshalJSON(jwk); err != nil {
+			return nil, fmt.Errorf("invalid jwk: %v", err)
+		}
+		if !proofKey.IsPublic() {
+			return nil, fmt.Errorf("jwk is not a public key")
+		}
+		if keyType, _, _ := keyTypeCurveAndSize(proofKey.Key); keyType != keyTypeForSigningAlg(token.Method.Alg()) {
+			return nil, fmt.Errorf("jwk does not match alg %s", token.Method.Alg())
+		}
+
+		return proofKey.Key, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrStatusInvalidDPoPProof, err)
+	}
+	if !proof.Valid {
+		return "", ErrStatusInvalidDPoPProof
+	}
+
+	if htm, _ := (*claims)[DPoPHTTPMethodClaim].(string); htm != method {
+		return "", fmt.Errorf("%w: htm mismatch", ErrStatusInvalidDPoPProof)
+	}
+	if htu, _ := (*claims)[DPoPHTTPURIClaim].(string); !isDPoPHTTPURIEqual(htu, uri) {
+		return "", fmt.Errorf("%w: htu mismatch", ErrStatusInvalidDPoPProof)
+	}
+	ath := sha256.Sum256([]byte(tokenString))
+	if v, _ := (*claims)[DPoPAccessTokenHashClaim].(string); v != base64.RawURLEncoding.EncodeToString(ath[:]) {
+		return "", fmt.Errorf("%w: ath mismatch", ErrStatusInvalidDPoPProof)
+	}
+
+	now := time.Now()
+	iat, ok := claims.Int64Claim(IssuedAtClaim)
+	if !ok {
+		return "", fmt.Errorf("%w: missing iat", ErrStatusInvalidDPoPProof)
+	}
+	issuedAt := time.Unix(iat, 0)
+	if issuedAt.Before(now.Add(-maxAge)) || issuedAt.After(now.Add(maxAge)) {
+		return "", fmt.Errorf("%w: iat out of range", ErrStatusInvalidDPoPProof)
+	}
+	jti, _ := (*claims)[IDClaim].(string)
+	if jti == "" {
+		return "", fmt.Errorf("%w: missing jti", ErrStatusInvalidDPoPProof)
+	}
+	if !p.dpopReplay.add(jti, issuedAt.Add(2*maxAge), now) {
+		return "", ErrStatusDPoPProofReplayed
+	}
+
+	thumbprint, err := proofKey.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrStatusInvalidDPoPProof, err)
+	}
+
+	return base64.RawURLEncoding.EncodeToString(thumbprint), nil
+}
+
+// isDPoPHTTPURIEqual compares the provided URIs without query and fragment as
+// defined in RFC 9449 section 4.3.
+func isDPoPHTTPURIEqual(htu string, uri string) bool {
+	a, err := url.Parse(htu)
+	if err != nil || htu == "" {
+		return false
+	}
+	b, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+
+	normalizePath := func(path string) string {
+		if path == "" {
+			return "/"
+		}
+		return path
+	}
+
+	return strings.EqualFold(a.Scheme, b.Scheme) &&
+		strings.EqualFold(a.Host, b.Host) &&
+		normalizePath(a.EscapedPath()) == normalizePath(b.EscapedPath())
+}
+
+// A replayCache remembers seen IDs until they expire, but at most size IDs.
+// Expired IDs are pruned in order of their expiration.
+type replayCache struct {
+	mutex   sync.Mutex
+	size    int
+	entries map[string]time.Time
+	queue   replayQueue
+}
+
+func newReplayCache(size int) *replayCache {
+	return &replayCache{
+		size:    size,
+		entries: make(map[string]time.Time),
+	}
+}
+
+// add adds the provided ID with the provided expiration and returns false if
+// the ID was already seen and has not expired.
+func (c *replayCache) add(id string, expires time.Time, now time.Time) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for len(c.queue) > 0 && now.After(c.queue[0].expires) {
+		c.pop()
+	}
+	if _, ok := c.entries[id]; ok {
+		return false
+	}
+	for len(c.queue) > 0 && len(c.queue) >= c.size {
+		c.pop()
+	}
+	c.entries[id] = expires
+	heap.Push(&c.queue, &replayEntry{id, expires})
+
+	return true
+}
+
+func (c *replayCache) pop() {
+	entry := heap.Pop(&c.queue).(*replayEntry)
+	delete(c.entries, entry.id)
+}
+
+type replayEntry struct {
+	id      string
+	expires time.Time
+}
+
+// A replayQueue is a min-heap of replayEntry ordered by expiration.
+type replayQueue []*replayEntry
+
+func (q replayQueue) Len() int {
+	return len(q)
+}
+
+func (q replayQueue) Less(i, j int) bool {
+	return q[i].expires.Before(q[j].expires)
+}
+
+func (q replayQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *replayQueue) Push(x interface{}) {
+	*q = append(*q, x.(*replayEntry))
+}
+
+func (q *replayQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	entry := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+
+	return entry
+}
@@ -0,0 +1,57 @@
+/*
+ * SPDX-License-Identifier: AGPL-3.0-or-later
+ * Copyright 2020 Kopano and its licensors
+ */
+
+package kcoidc
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestReplayCache(t *testing.T) {
+	c := newReplayCache(3)
+	now := time.Now()
+
+	if !c.add("a", now.Add(3*time.Minute), now) || !c.add("b", now.Add(time.Minute), now) {
+		t.Fatal("unexpected replay for new IDs")
+	}
+	if c.add("a", now.Add(3*time.Minute), now) {
+		t.Error("unexpected success for replayed ID")
+	}
+
+	// Expired IDs are pruned and can be added again.
+	now = now.Add(2 * time.Minute)
+	if !c.add("b", now.Add(4*time.Minute), now) {
+		t.Error("unexpected replay for expired ID")
+	}
+	if c.add("a", now.Add(time.Minute), now) {
+		t.Error("unexpected success for replayed ID which has not expired")
+	}
+
+	// Once full, the IDs which expire first are dropped.
+	if !c.add("c", now.Add(5*time.Minute), now) || !c.add("d", now.Add(5*time.Minute), now) {
+		t.Fatal("unexpected replay for new IDs")
+	}
+	if len(c.entries) != 3 || len(c.queue) != 3 {
+		t.Errorf("unexpected size: %d, %d", len(c.entries), len(c.queue))
+	}
+	if _, ok := c.entries["a"]; ok {
+		t.Error("unexpected ID which expires first after exceeding the size")
+	}
+	if c.add("c", now.Add(5*time.Minute), now) {
+		t.Error("unexpected success for replayed ID")
+	}
+}
+
+func BenchmarkReplayCacheAdd(b *testing.B) {
+	c := newReplayCache(DefaultDPoPReplayCacheSize)
+	now := time.Now()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.add(fmt.Sprintf("jti-%d", i), now.Add(time.Duration(i%600)*time.Second), now)
+	}
+}
@@ -7,6 +7,7 @@ package kcoidc
 
 import (
 	"fmt"
+	"strings"
 )
 
 // ErrStatus is the Error type as used by kcoidc.
@@ -33,6 +34,44 @@ const (
 	ErrStatusClosed
 	ErrStatusWrongInitialization
 	ErrStatusMissingRequiredScope
+	ErrStatusTokenTypeNotAllowed
+	ErrStatusTokenUnexpectedType
+	ErrStatusUnknownClaimsProfile
+	ErrStatusMissingUserIDClaim
+	ErrStatusMissingRequiredRole
+	ErrStatusMissingAnyRole
+	ErrStatusInvalidPolicy
+	ErrStatusPolicyDenied
+	ErrStatusInvalidClaimsRequest
+	ErrStatusMissingRequiredClaim
+	ErrStatusInvalidGuestPolicy
+	ErrStatusGuestDenied
+	ErrStatusMissingIdentityClaim
+	ErrStatusInvalidIdentityClaim
+	ErrStatusInvalidSigningAlg
+	ErrStatusTokenKeyRejected
+	ErrStatusTokenDecryptionFailed
+	ErrStatusInvalidDecryptionKey
+	ErrStatusInvalidDPoPProof
+	ErrStatusDPoPProofReplayed
+	ErrStatusDPoPBindingMismatch
+	ErrStatusCertificateBindingMismatch
+	ErrStatusInvalidCertificate
+	ErrStatusTokenUnsupportedCritical
+	ErrStatusTokenEmbeddedKey
+	ErrStatusTokenTooLarge
+	ErrStatusTokenClaimsTooDeep
+	ErrStatusInvalidKey
+	ErrStatusInvalidStalenessPolicy
+	ErrStatusProviderStale
+	ErrStatusRefreshFailed
+	ErrStatusDNSFailure
+	ErrStatusTLSFailure
+	ErrStatusHTTPFailure
+	ErrStatusInvalidProviderResponse
+	ErrStatusInvalidTokenLimits
+	ErrStatusInvalidKeyGracePeriod
+	ErrStatusInvalidRefreshPolicy
 )
 
 // StatusSuccess is the success response as returned by this library.
@@ -54,6 +93,44 @@ var ErrStatusTextMap = map[ErrStatus]string{
 	ErrStatusClosed:                       "Is Closed",
 	ErrStatusWrongInitialization:          "Wrong Initialization",
 	ErrStatusMissingRequiredScope:         "Missing required scope",
+	ErrStatusTokenTypeNotAllowed:          "Token type not allowed",
+	ErrStatusTokenUnexpectedType:          "Unexpected Token Type Header",
+	ErrStatusUnknownClaimsProfile:         "Unknown Claims Profile",
+	ErrStatusMissingUserIDClaim:           "Missing User ID Claim",
+	ErrStatusMissingRequiredRole:          "Missing required role",
+	ErrStatusMissingAnyRole:               "Missing any of the roles",
+	ErrStatusInvalidPolicy:                "Invalid Policy",
+	ErrStatusPolicyDenied:                 "Denied By Policy",
+	ErrStatusInvalidClaimsRequest:         "Invalid Claims Request",
+	ErrStatusMissingRequiredClaim:         "Missing required claim",
+	ErrStatusInvalidGuestPolicy:           "Invalid Guest Policy",
+	ErrStatusGuestDenied:                  "Guest Denied",
+	ErrStatusMissingIdentityClaim:         "Missing Identity Claim",
+	ErrStatusInvalidIdentityClaim:         "Invalid Identity Claim",
+	ErrStatusInvalidSigningAlg:            "Invalid Signing Algorithm",
+	ErrStatusTokenKeyRejected:             "Token Key Rejected",
+	ErrStatusTokenDecryptionFailed:        "Token Decryption Failed",
+	ErrStatusInvalidDecryptionKey:         "Invalid Decryption Key",
+	ErrStatusInvalidDPoPProof:             "Invalid DPoP Proof",
+	ErrStatusDPoPProofReplayed:            "DPoP Proof Replayed",
+	ErrStatusDPoPBindingMismatch:          "DPoP Binding Mismatch",
+	ErrStatusCertificateBindingMismatch:   "Certificate Binding Mismatch",
+	ErrStatusInvalidCertificate:           "Invalid Certificate",
+	ErrStatusTokenUnsupportedCritical:     "Unsupported Critical Token Header",
+	ErrStatusTokenEmbeddedKey:             "Token With Embedded Key Reference",
+	ErrStatusTokenTooLarge:                "Token Too Large",
+	ErrStatusTokenClaimsTooDeep:           "Token Claims Nested Too Deep",
+	ErrStatusInvalidKey:                   "Invalid Key",
+	ErrStatusInvalidStalenessPolicy:       "Invalid Staleness Policy",
+	ErrStatusProviderStale:                "Provider Definition Stale",
+	ErrStatusRefreshFailed:                "Refresh Failed",
+	ErrStatusDNSFailure:                   "DNS Lookup Failed",
+	ErrStatusTLSFailure:                   "TLS Failure",
+	ErrStatusHTTPFailure:                  "HTTP Request Failed",
+	ErrStatusInvalidProviderResponse:      "Invalid Provider Response",
+	ErrStatusInvalidTokenLimits:           "Invalid Token Limits",
+	ErrStatusInvalidKeyGracePeriod:        "Invalid Key Grace Period",
+	ErrStatusInvalidRefreshPolicy:         "Invalid Refresh Policy",
 }
 
 // ErrStatusText returns a text for the ErrStatus. It returns the empty string
@@ -62,3 +139,34 @@ func ErrStatusText(code ErrStatus) string {
 	text := ErrStatusTextMap[code]
 	return text
 }
+
+// MissingScopesError is the error returned when required scopes are not
+// found in claims. It wraps ErrStatusMissingRequiredScope, thus it must be
+// compared with errors.Is instead of ==.
+type MissingScopesError struct {
+	Scopes []string
+}
+
+func (err *MissingScopesError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrStatusMissingRequiredScope.Error(), strings.Join(err.Scopes, " "))
+}
+
+// Unwrap returns the ErrStatus of the accociated error.
+func (err *MissingScopesError) Unwrap() error {
+	return ErrStatusMissingRequiredScope
+}
+
+// MissingClaimsError is the error returned when requested claims are not
+// authorized in claims. It wraps ErrStatusMissingRequiredClaim.
+type MissingClaimsError struct {
+	Claims []string
+}
+
+func (err *MissingClaimsError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrStatusMissingRequiredClaim.Error(), strings.Join(err.Claims, " "))
+}
+
+// Unwrap returns the ErrStatus of the accociated error.
+func (err *MissingClaimsError) Unwrap() error {
+	return ErrStatusMissingRequiredClaim
+}
@@ -11,12 +11,29 @@ import (
 	"fmt"
 	"net/http"
 	"strings"
+	"time"
+
+	"github.com/pquerna/cachecontrol"
 )
 
-func fetchJSON(ctx context.Context, client *http.Client, url string, headers http.Header, validContentTypes []string, target interface{}) error {
+// An httpStatusError is returned when a fetch results in an unexpected HTTP
+// response status.
+type httpStatusError struct {
+	StatusCode int
+}
+
+func (err *httpStatusError) Error() string {
+	return fmt.Sprintf("unexpected response status: %d %s", err.StatusCode, http.StatusText(err.StatusCode))
+}
+
+// fetchJSON fetches the provided url and decodes the JSON response into the
+// provided target. It also returns the time until which the response may be
+// cached according to its HTTP caching headers, or the zero time if it may not
+// be cached.
+func fetchJSON(ctx context.Context, client *http.Client, url string, headers http.Header, validContentTypes []string, requireSuccessStatus bool, target interface{}) (time.Time, error) {
 	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		return err
+		return time.Time{}, err
 	}
 
 	if client == nil {
@@ -29,10 +46,16 @@ func fetchJSON(ctx context.Context, client *http.Client, url string, headers htt
 	req = req.WithContext(ctx)
 	response, err := client.Do(req)
 	if err != nil {
-		return err
+		return time.Time{}, err
 	}
 	defer response.Body.Close()
 
+	if requireSuccessStatus && (response.StatusCode < 200 || response.StatusCode > 299) {
+		return time.Time{}, &httpStatusError{
+			StatusCode: response.StatusCode,
+		}
+	}
+
 	if len(validContentTypes) > 0 {
 		contentType := strings.SplitN(response.Header.Get("Content-Type"), ";", 2)[0]
 		valid := false
@@ -43,9 +66,14 @@ func fetchJSON(ctx context.Context, client *http.Client, url string, headers htt
 			}
 		}
 		if !valid {
-			return fmt.Errorf("unexpected response content-type: %s", contentType)
+			return time.Time{}, fmt.Errorf("unexpected response content-type: %s", contentType)
 		}
 	}
 
-	return json.NewDecoder(response.Body).Decode(target)
+	if err = json.NewDecoder(response.Body).Decode(target); err != nil {
+		return time.Time{}, err
+	}
+
+	_, expires, _ := cachecontrol.CachableResponse(req, response, cachecontrol.Options{})
+	return expires, nil
 }
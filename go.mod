@@ -5,6 +5,8 @@ go 1.13
 require (
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
 	github.com/openkop/oidc-go v0.3.3-0.20231021150512-5da8e2dfa038
+	github.com/pquerna/cachecontrol v0.0.0-20180517163645-1555304b9b35
 	golang.org/x/net v0.0.0-20190424112056-4829fb13d2c6
 	golang.org/x/text v0.3.1 // indirect
+	gopkg.in/square/go-jose.v2 v2.4.0
 )
@@ -0,0 +1,73 @@
+/*
+ * SPDX-License-Identifier: AGPL-3.0-or-later
+ * Copyright 2020 Kopano and its licensors
+ */
+
+package kcoidc
+
+import (
+	"strings"
+)
+
+// Guest policies define how a Provider treats tokens of guest identities.
+const (
+	GuestPolicyAllow = iota
+	GuestPolicyDeny
+	GuestPolicyRestrictScopes
+)
+
+// validateGuestPolicy returns nil if the provided claims pass the provided
+// guest policy. With GuestPolicyRestrictScopes, guest claims are accepted but
+// their authorized scopes are restricted to the provided allowed scopes, which
+// can use the ScopeWildcardSuffix. Otherwise ErrStatusGuestDenied is returned.
+func validateGuestPolicy(guestPolicy int, allowedScopes []string, claims *ExtraClaimsWithType, isGuest bool) error {
+	if !isGuest {
+		return nil
+	}
+
+	switch guestPolicy {
+	case GuestPolicyAllow:
+		return nil
+	case GuestPolicyDeny:
+		return ErrStatusGuestDenied
+	case GuestPolicyRestrictScopes:
+		restrictScopesInClaims(allowedScopes, claims)
+		return nil
+	default:
+		return ErrStatusInvalidGuestPolicy
+	}
+}
+
+// restrictScopesInClaims removes all scopes which are not included in the
+// provided allowed scopes from the scope claims of the provided claims, so
+// that requiring any other scope fails.
+func restrictScopesInClaims(allowedScopes []string, claims *ExtraClaimsWithType) {
+	if authorizedScopes, ok := (*claims)[AuthorizedScopesClaim].([]interface{}); ok {
+		restricted := make([]interface{}, 0, len(authorizedScopes))
+		for _, scope := range authorizedScopes {
+			if s, _ := scope.(string); isScopeAllowed(allowedScopes, s) {
+				restricted = append(restricted, scope)
+			}
+		}
+		(*claims)[AuthorizedScopesClaim] = restricted
+	}
+	if scopeValue, ok := (*claims)[ScopeClaim].(string); ok {
+		restricted := make([]string, 0)
+		for _, scope := range strings.Fields(scopeValue) {
+			if isScopeAllowed(allowedScopes, scope) {
+				restricted = append(restricted, scope)
+			}
+		}
+		(*claims)[ScopeClaim] = strings.Join(restricted, " ")
+	}
+}
+
+func isScopeAllowed(allowedScopes []string, scope string) bool {
+	for _, allowedScope := range allowedScopes {
+		if isScopeAuthorized(map[string]bool{scope: true}, allowedScope) {
+			return true
+		}
+	}
+
+	return false
+}
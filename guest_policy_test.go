@@ -0,0 +1,43 @@
+/*
+ * SPDX-License-Identifier: AGPL-3.0-or-later
+ * Copyright 2020 Kopano and its licensors
+ */
+
+package kcoidc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateGuestPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		guestPolicy   int
+		allowedScopes []string
+		isGuest       bool
+		err           error
+		scopes        map[string]bool
+	}{
+		{GuestPolicyAllow, nil, true, nil, map[string]bool{"openid": true, "kopano/kwm": true}},
+		{GuestPolicyDeny, nil, false, nil, map[string]bool{"openid": true, "kopano/kwm": true}},
+		{GuestPolicyDeny, nil, true, ErrStatusGuestDenied, map[string]bool{"openid": true, "kopano/kwm": true}},
+		{GuestPolicyRestrictScopes, []string{"openid", "kopano/*"}, true, nil, map[string]bool{"openid": true, "kopano/kwm": true}},
+		{GuestPolicyRestrictScopes, []string{"openid"}, true, nil, map[string]bool{"openid": true}},
+		{GuestPolicyRestrictScopes, []string{"openid"}, false, nil, map[string]bool{"openid": true, "kopano/kwm": true}},
+		{42, nil, true, ErrStatusInvalidGuestPolicy, map[string]bool{"openid": true, "kopano/kwm": true}},
+	} {
+		claims := &ExtraClaimsWithType{
+			AuthorizedScopesClaim: []interface{}{"openid", "kopano/kwm"},
+			ScopeClaim:            "openid kopano/kwm",
+		}
+		if err := validateGuestPolicy(tc.guestPolicy, tc.allowedScopes, claims, tc.isGuest); err != tc.err {
+			t.Errorf("unexpected result for policy %d with %v: got %v, want %v", tc.guestPolicy, tc.allowedScopes, err, tc.err)
+		}
+		if scopes := KonnectClaimsProfile.AuthorizedScopes(claims); !reflect.DeepEqual(scopes, tc.scopes) {
+			t.Errorf("unexpected authorized scopes for policy %d with %v: got %v, want %v", tc.guestPolicy, tc.allowedScopes, scopes, tc.scopes)
+		}
+		if scopes := GenericClaimsProfile.AuthorizedScopes(claims); !reflect.DeepEqual(scopes, tc.scopes) {
+			t.Errorf("unexpected scopes for policy %d with %v: got %v, want %v", tc.guestPolicy, tc.allowedScopes, scopes, tc.scopes)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+/*
+ * SPDX-License-Identifier: AGPL-3.0-or-later
+ * Copyright 2020 Kopano and its licensors
+ */
+
+package kcoidc
+
+// JOSE header parameters which are processed explicitly.
+const (
+	CriticalHeader   = "crit"
+	JWKSetURLHeader  = "jku"
+	JSONWebKeyHeader = "jwk"
+	X509URLHeader    = "x5u"
+	X509ChainHeader  = "x5c"
+)
+
+// SupportedCriticalHeaders lists the header parameters which are understood
+// when listed in the crit header of a token. Tokens with other critical header
+// parameters are rejected as defined in RFC 7515 section 4.1.11.
+var SupportedCriticalHeaders = []string{}
+
+// DefaultMaxTokenLength and DefaultMaxClaimsDepth are the token limits which
+// are used by a Provider if not explicitly configured otherwise.
+var (
+	DefaultMaxTokenLength = 64 * 1024
+	DefaultMaxClaimsDepth = 16
+)
+
+// validateHeader returns nil if the provided JOSE header can be processed.
+// Tokens with unknown critical header parameters are rejected with
+// ErrStatusTokenUnsupportedCritical. Tokens which reference or embed their own
+// keys are rejected with ErrStatusTokenEmbeddedKey, since keys are only ever
+// taken from the Provider.
+func validateHeader(header map[string]interface{}) error {
+	for _, name := range []string{JWKSetURLHeader, JSONWebKeyHeader, X509URLHeader, X509ChainHeader} {
+		if _, ok := header[name]; ok {
+			return ErrStatusTokenEmbeddedKey
+		}
+	}
+
+	if value, ok := header[CriticalHeader]; ok {
+		critical, _ := value.([]interface{})
+		if len(critical) == 0 {
+			// Must not be empty if present.
+			return ErrStatusTokenUnsupportedCritical
+		}
+		for _, v := range critical {
+			name, _ := v.(string)
+			if _, present := header[name]; !present || !isStringInSlice(SupportedCriticalHeaders, name) {
+				return ErrStatusTokenUnsupportedCritical
+			}
+		}
+	}
+
+	return nil
+}
+
+// claimsDepth returns the nesting depth of the provided claim value, where
+// values which are neither objects nor arrays have a depth of zero. Counting
+// stops once the provided limit is exceeded, so the result is at most limit+1.
+func claimsDepth(value interface{}, limit int) int {
+	if limit < 0 {
+		return 0
+	}
+
+	depth := 0
+	switch vt := value.(type) {
+	case map[string]interface{}:
+		for _, v := range vt {
+			if d := claimsDepth(v, limit-1); d > depth {
+				depth = d
+			}
+		}
+	case []interface{}:
+		for _, v := range vt {
+			if d := claimsDepth(v, limit-1); d > depth {
+				depth = d
+			}
+		}
+	default:
+		return 0
+	}
+
+	return depth + 1
+}
@@ -0,0 +1,110 @@
+/*
+ * SPDX-License-Identifier: AGPL-3.0-or-later
+ * Copyright 2020 Kopano and its licensors
+ */
+
+package kcoidc
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Identity claims used by Kopano Konnect in the IdentityClaim.
+const (
+	IdentifiedUsernameClaim    = "kc.i.un"
+	IdentifiedDisplayNameClaim = "kc.i.dn"
+)
+
+// EmailClaim is the standard OpenID Connect email claim.
+const EmailClaim = "email"
+
+// A KonnectIdentity is the typed representation of the Kopano Konnect identity
+// claims of a token.
+type KonnectIdentity struct {
+	UserID      string
+	IsGuest     bool
+	Username    string
+	DisplayName string
+	Email       string
+
+	// Claims holds all other claims found in the identity claim.
+	Claims map[string]interface{}
+}
+
+// KonnectIdentityFromClaims decodes the Kopano Konnect identity claims of the
+// provided extra claims. ErrStatusMissingIdentityClaim is returned if the
+// claims have no identity claim or no identified user ID. Identity claims with
+// an unexpected type result in an error wrapping ErrStatusInvalidIdentityClaim.
+func KonnectIdentityFromClaims(claims *ExtraClaimsWithType) (*KonnectIdentity, error) {
+	value, ok := (*claims)[IdentityClaim]
+	if !ok {
+		return nil, ErrStatusMissingIdentityClaim
+	}
+	identityClaims, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: %s is not an object", ErrStatusInvalidIdentityClaim, IdentityClaim)
+	}
+
+	identity := &KonnectIdentity{
+		Claims: make(map[string]interface{}),
+	}
+	for k, v := range identityClaims {
+		identity.Claims[k] = v
+	}
+
+	var err error
+	if identity.UserID, err = popIdentityString(identity.Claims, IdentifiedUserIDClaim); err != nil {
+		return nil, err
+	}
+	if identity.UserID == "" {
+		return nil, ErrStatusMissingIdentityClaim
+	}
+	if v, ok := popFromMap(identity.Claims, IdentifiedUserIsGuest); ok {
+		if identity.IsGuest, ok = v.(bool); !ok {
+			return nil, fmt.Errorf("%w: %s is not a bool", ErrStatusInvalidIdentityClaim, IdentifiedUserIsGuest)
+		}
+	}
+	if identity.Username, err = popIdentityString(identity.Claims, IdentifiedUsernameClaim); err != nil {
+		return nil, err
+	}
+	if identity.DisplayName, err = popIdentityString(identity.Claims, IdentifiedDisplayNameClaim); err != nil {
+		return nil, err
+	}
+	if v, ok := (*claims)[EmailClaim]; ok {
+		if identity.Email, ok = v.(string); !ok {
+			return nil, fmt.Errorf("%w: %s is not a string", ErrStatusInvalidIdentityClaim, EmailClaim)
+		}
+	}
+
+	return identity, nil
+}
+
+func popIdentityString(claims map[string]interface{}, claim string) (string, error) {
+	v, ok := popFromMap(claims, claim)
+	if !ok {
+		return "", nil
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%w: %s is not a string", ErrStatusInvalidIdentityClaim, claim)
+	}
+
+	return s, nil
+}
+
+// MarshalJSON encodes the accociated KonnectIdentity as flat JSON object. All
+// other identity claims are included with their claim name as key.
+func (identity *KonnectIdentity) MarshalJSON() ([]byte, error) {
+	m := make(map[string]interface{})
+	for k, v := range identity.Claims {
+		m[k] = v
+	}
+	m["user_id"] = identity.UserID
+	m["guest"] = identity.IsGuest
+	m["username"] = identity.Username
+	m["display_name"] = identity.DisplayName
+	m["email"] = identity.Email
+
+	return json.Marshal(m)
+}
@@ -0,0 +1,145 @@
+/*
+ * SPDX-License-Identifier: AGPL-3.0-or-later
+ * Copyright 2020 Kopano and its licensors
+ */
+
+package kcoidc
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/url"
+	"time"
+)
+
+// DefaultInitializeMaxBackoff is the maximum backoff between retries of a
+// failed initialization if not explicitly configured otherwise.
+var DefaultInitializeMaxBackoff = 5 * time.Minute
+
+// SetInitializeRetry sets if the associated Provider keeps retrying in the
+// background when the discovery document or JWKS cannot be fetched during
+// initialize, with exponential backoff between the provided minimum and
+// maximum. If zero is provided, DefaultRefreshMinBackoff and
+// DefaultInitializeMaxBackoff are used respectively. Retrying is enabled by
+// default, WaitUntilReady then returns the error of the last attempt on
+// timeout. Disable it to make initialize fail fast and return such errors
+// directly. It must be called before the call to initialize.
+func (p *Provider) SetInitializeRetry(retry bool, minBackoff time.Duration, maxBackoff time.Duration) error {
+	if minBackoff < 0 || maxBackoff < 0 {
+		return ErrStatusWrongInitialization
+	}
+	if minBackoff == 0 {
+		minBackoff = DefaultRefreshMinBackoff
+	}
+	if maxBackoff == 0 {
+		maxBackoff = DefaultInitializeMaxBackoff
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.initialized {
+		return ErrStatusAlreadyInitialized
+	}
+	p.initializeRetry = retry
+	p.initializeMinBackoff = minBackoff
+	p.initializeMaxBackoff = maxBackoff
+
+	return nil
+}
+
+// initializeDefinition fetches the definition of the associated Provider with
+// exponential backoff between failed attempts until it succeeds or the
+// provided context is done. It returns the expiry of the fetched definition,
+// or false if the provided context is done first.
+func (p *Provider) initializeDefinition(ctx context.Context, minBackoff time.Duration, maxBackoff time.Duration) (time.Time, bool) {
+	failures := 0
+	for {
+		if failures > 0 {
+			select {
+			case <-ctx.Done():
+				return time.Time{}, false
+			case <-time.After(backoffDelay(failures, minBackoff, maxBackoff)):
+			}
+		}
+
+		p.mutex.RLock()
+		issuer := p.issuer
+		p.mutex.RUnlock()
+		definition, expires, err := p.fetchDefinition(ctx, issuer)
+
+		p.mutex.Lock()
+		if ctx.Err() != nil || !p.initialized {
+			p.mutex.Unlock()
+			return time.Time{}, false
+		}
+		if err != nil {
+			failures++
+			p.initializeError = err
+			if p.logger != nil {
+				p.logger.Printf("kcoidc initialize attempt %d failed, retrying: %v\n", failures, err)
+			}
+			p.mutex.Unlock()
+			continue
+		}
+		p.initializeError = nil
+		p.applyDefinition(definition, time.Now())
+		p.mutex.Unlock()
+		return expires, true
+	}
+}
+
+// backoffDelay returns the exponential backoff after the provided number of
+// consecutive failures, starting at the provided minimum and bounded by the
+// provided maximum.
+func backoffDelay(failures int, minBackoff time.Duration, maxBackoff time.Duration) time.Duration {
+	backoff := minBackoff
+	for i := 1; i < failures && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+
+	return backoff
+}
+
+// fetchErrorStatus returns the ErrStatus which describes the kind of the
+// provided fetch error.
+func fetchErrorStatus(err error) ErrStatus {
+	var dnsErr *net.DNSError
+	var statusErr *httpStatusError
+	var urlErr *url.Error
+	switch {
+	case errors.As(err, &dnsErr):
+		return ErrStatusDNSFailure
+	case isTLSError(err):
+		return ErrStatusTLSFailure
+	case errors.As(err, &statusErr), errors.As(err, &urlErr):
+		return ErrStatusHTTPFailure
+	}
+
+	// Everything else failed after the response was received.
+	return ErrStatusInvalidProviderResponse
+}
+
+func isTLSError(err error) bool {
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certificateInvalidErr x509.CertificateInvalidError
+	var systemRootsErr x509.SystemRootsError
+	var constraintViolationErr x509.ConstraintViolationError
+	var unhandledCriticalExtensionErr x509.UnhandledCriticalExtension
+	var recordHeaderErr tls.RecordHeaderError
+
+	return errors.As(err, &unknownAuthorityErr) ||
+		errors.As(err, &hostnameErr) ||
+		errors.As(err, &certificateInvalidErr) ||
+		errors.As(err, &systemRootsErr) ||
+		errors.As(err, &constraintViolationErr) ||
+		errors.As(err, &unhandledCriticalExtensionErr) ||
+		errors.As(err, &recordHeaderErr)
+}
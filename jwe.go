@@ -0,0 +1,162 @@
+/*
+ * SPDX-License-Identifier: AGPL-3.0-or-later
+ * Copyright 2020 Kopano and its licensors
+ */
+
+package kcoidc
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+// SupportedKeyManagementAlgs lists the JWE key management algorithms which are
+// accepted when decrypting encrypted tokens.
+var SupportedKeyManagementAlgs = []string{
+	string(jose.RSA_OAEP),
+	string(jose.RSA_OAEP_256),
+	string(jose.ECDH_ES),
+	string(jose.ECDH_ES_A128KW),
+	string(jose.ECDH_ES_A192KW),
+	string(jose.ECDH_ES_A256KW),
+}
+
+// SupportedContentEncryptionAlgs lists the JWE content encryption algorithms
+// which are accepted when decrypting encrypted tokens.
+var SupportedContentEncryptionAlgs = []string{
+	string(jose.A128CBC_HS256),
+	string(jose.A192CBC_HS384),
+	string(jose.A256CBC_HS512),
+	string(jose.A128GCM),
+	string(jose.A192GCM),
+	string(jose.A256GCM),
+}
+
+// isEncryptedToken returns true if the provided token string is in JWE compact
+// serialization, which has five instead of three parts.
+func isEncryptedToken(tokenString string) bool {
+	return strings.Count(tokenString, ".") == 4
+}
+
+// CompressionHeader is the JWE header parameter which marks the payload as
+// compressed. Compressed tokens are rejected, as decompression is not bounded.
+const CompressionHeader = "zip"
+
+// decryptToken decrypts the provided JWE compact serialized token string with
+// the matching key of the provided keys and returns the decrypted payload,
+// which is the inner signed token.
+func decryptToken(tokenString string, keys []jose.JSONWebKey) (string, error) {
+	if len(keys) == 0 {
+		return "", ErrStatusTokenDecryptionFailed
+	}
+
+	obj, err := jose.ParseEncrypted(tokenString)
+	if err != nil {
+		return "", ErrStatusTokenMalformed
+	}
+
+	if !isStringInSlice(SupportedKeyManagementAlgs, obj.Header.Algorithm) {
+		return "", ErrStatusTokenDecryptionFailed
+	}
+	enc, _ := obj.Header.ExtraHeaders["enc"].(string)
+	if !isStringInSlice(SupportedContentEncryptionAlgs, enc) {
+		return "", ErrStatusTokenDecryptionFailed
+	}
+	if _, ok := obj.Header.ExtraHeaders[CompressionHeader]; ok {
+		return "", ErrStatusTokenDecryptionFailed
+	}
+
+	for _, key := range keys {
+		if obj.Header.KeyID != "" && key.KeyID != "" && key.KeyID != obj.Header.KeyID {
+			continue
+		}
+		if payload, decryptErr := obj.Decrypt(key.Key); decryptErr == nil {
+			return string(payload), nil
+		}
+	}
+
+	return "", ErrStatusTokenDecryptionFailed
+}
+
+// LoadDecryptionKeysFile loads private decryption keys from the file with the
+// provided name. The file can either contain a JWK set, a single JWK, or one
+// or more PEM encoded private keys in PKCS #1, PKCS #8 or SEC 1 format.
+func LoadDecryptionKeysFile(fn string) ([]jose.JSONWebKey, error) {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrStatusInvalidDecryptionKey, err)
+	}
+
+	var keys []jose.JSONWebKey
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		keys, err = parseJWKSetOrKey(trimmed)
+	} else {
+		keys, err = parsePEMPrivateKeys(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrStatusInvalidDecryptionKey, err)
+	}
+	for _, key := range keys {
+		if key.IsPublic() {
+			return nil, fmt.Errorf("%w: key %s is not a private key", ErrStatusInvalidDecryptionKey, key.KeyID)
+		}
+	}
+
+	return keys, nil
+}
+
+func parseJWKSetOrKey(data []byte) ([]jose.JSONWebKey, error) {
+	jwks := &jose.JSONWebKeySet{}
+	if err := json.Unmarshal(data, jwks); err == nil && len(jwks.Keys) > 0 {
+		return jwks.Keys, nil
+	}
+
+	key := jose.JSONWebKey{}
+	if err := json.Unmarshal(data, &key); err != nil {
+		return nil, err
+	}
+
+	return []jose.JSONWebKey{key}, nil
+}
+
+func parsePEMPrivateKeys(data []byte) ([]jose.JSONWebKey, error) {
+	var keys []jose.JSONWebKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		var key interface{}
+		var err error
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+		case "EC PRIVATE KEY":
+			key, err = x509.ParseECPrivateKey(block.Bytes)
+		case "PRIVATE KEY":
+			key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, jose.JSONWebKey{
+			Key: key,
+		})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no PEM private key found")
+	}
+
+	return keys, nil
+}
@@ -0,0 +1,442 @@
+/*
+ * SPDX-License-Identifier: AGPL-3.0-or-later
+ * Copyright 2020 Kopano and its licensors
+ */
+
+package kcoidc
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// Key types as used in JWKs.
+const (
+	KeyTypeRSA = "RSA"
+	KeyTypeEC  = "EC"
+	KeyTypeOKP = "OKP"
+)
+
+// A KeyPolicy defines the requirements for keys to be used for token signature
+// verification. Keys which do not conform are excluded from key selection.
+type KeyPolicy struct {
+	// MinRSAKeySize is the minimum RSA modulus size in bits.
+	MinRSAKeySize int
+	// AllowedCurves lists the allowed EC and OKP curves, for example "P-256"
+	// or "Ed25519". If empty, all curves are allowed.
+	AllowedCurves []string
+	// AllowedKeyTypes lists the allowed key types, for example KeyTypeRSA. If
+	// empty, all key types are allowed.
+	AllowedKeyTypes []string
+	// CertificateRoots is the CA pool to validate the x5c certificate chains
+	// of keys against. If set, keys are only accepted with a x5c chain which
+	// validates against the pool and whose leaf certificate holds the key.
+	// Chains are validated whenever the JWKS is loaded.
+	CertificateRoots *x509.CertPool
+}
+
+// Check returns nil if the provided key conforms to the accociated KeyPolicy.
+// Otherwise an error describing the reason is returned.
+func (policy *KeyPolicy) Check(key *jose.JSONWebKey) error {
+	keyType, curve, size := keyTypeCurveAndSize(key.Key)
+	if keyType == "" {
+		return fmt.Errorf("unsupported key %T", key.Key)
+	}
+	if policy == nil {
+		return nil
+	}
+
+	if len(policy.AllowedKeyTypes) > 0 && !isStringInSlice(policy.AllowedKeyTypes, keyType) {
+		return fmt.Errorf("key type %s not allowed", keyType)
+	}
+	if keyType == KeyTypeRSA && size < policy.MinRSAKeySize {
+		return fmt.Errorf("RSA key size %d below minimum %d", size, policy.MinRSAKeySize)
+	}
+	if curve != "" && len(policy.AllowedCurves) > 0 && !isStringInSlice(policy.AllowedCurves, curve) {
+		return fmt.Errorf("curve %s not allowed", curve)
+	}
+	if policy.CertificateRoots != nil {
+		if err := verifyKeyCertificates(key, policy.CertificateRoots, time.Now()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// verifyKeyCertificates verifies the x5c certificate chain of the provided key
+// against the provided roots and checks that the leaf certificate holds the
+// public key of the provided key.
+func verifyKeyCertificates(key *jose.JSONWebKey, roots *x509.CertPool, now time.Time) error {
+	if len(key.Certificates) == 0 {
+		return fmt.Errorf("no x5c certificate chain")
+	}
+
+	leaf := key.Certificates[0]
+	keyBytes, err := x509.MarshalPKIXPublicKey(key.Key)
+	if err != nil {
+		return fmt.Errorf("unsupported key for x5c check: %v", err)
+	}
+	leafKeyBytes, err := x509.MarshalPKIXPublicKey(leaf.PublicKey)
+	if err != nil || !bytes.Equal(keyBytes, leafKeyBytes) {
+		return fmt.Errorf("x5c leaf certificate does not match key")
+	}
+
+	intermediates := x509.NewCertPool()
+	for _, cert := range key.Certificates[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err = leaf.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		CurrentTime:   now,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return fmt.Errorf("x5c certificate chain invalid: %v", err)
+	}
+
+	return nil
+}
+
+// LoadCertificateRootsFile loads the PEM encoded CA certificates from the file
+// with the provided name into a new certificate pool, suitable to be used as
+// KeyPolicy CertificateRoots.
+func LoadCertificateRootsFile(fn string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrStatusInvalidCertificate, err)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%w: no PEM certificate found", ErrStatusInvalidCertificate)
+	}
+
+	return roots, nil
+}
+
+// A KeyStatus describes a key of the JWKS of a Provider and if it is used for
+// token signature verification.
+type KeyStatus struct {
+	KeyID     string `json:"kid"`
+	KeyType   string `json:"kty"`
+	Algorithm string `json:"alg,omitempty"`
+	Use       string `json:"use,omitempty"`
+	Curve     string `json:"crv,omitempty"`
+	Size      int    `json:"size,omitempty"`
+
+	Source     string `json:"source"`
+	Thumbprint string `json:"thumbprint,omitempty"`
+
+	RetiredAt    int64 `json:"retired_at,omitempty"`
+	RetiredUntil int64 `json:"retired_until,omitempty"`
+
+	CertificateSubject string `json:"x5c_subject,omitempty"`
+
+	Accepted bool   `json:"accepted"`
+	Reason   string `json:"reason,omitempty"`
+}
+
+// Sources of keys as reported in KeyStatus.
+const (
+	KeySourceJWKS    = "jwks"
+	KeySourceExtra   = "extra"
+	KeySourceRetired = "retired"
+)
+
+// A retiredKey is a key which was removed from the JWKS of a Provider and is
+// still accepted until it expires.
+type retiredKey struct {
+	key       jose.JSONWebKey
+	retiredAt time.Time
+	expires   time.Time
+}
+
+// retireKeys returns the provided retired keys which are not expired at the
+// provided time and not in the provided current JWKS, together with all keys
+// of the provided previous JWKS which are not in the current JWKS, retired at
+// the provided time until the provided expiration.
+func retireKeys(retired []*retiredKey, previous *jose.JSONWebKeySet, current *jose.JSONWebKeySet, now time.Time, expires time.Time) []*retiredKey {
+	isCurrent := func(key *jose.JSONWebKey) bool {
+		if current == nil {
+			return false
+		}
+		thumbprint := keyThumbprint(key)
+		for idx := range current.Keys {
+			if current.Keys[idx].KeyID == key.KeyID && keyThumbprint(&current.Keys[idx]) == thumbprint {
+				return true
+			}
+		}
+		return false
+	}
+
+	result := make([]*retiredKey, 0, len(retired))
+	for _, rk := range retired {
+		if now.Before(rk.expires) && !isCurrent(&rk.key) {
+			result = append(result, rk)
+		}
+	}
+	if previous != nil && previous != current && now.Before(expires) {
+		for idx := range previous.Keys {
+			key := previous.Keys[idx]
+			if !isCurrent(&key) {
+				result = append(result, &retiredKey{
+					key:       key,
+					retiredAt: now,
+					expires:   expires,
+				})
+			}
+		}
+	}
+
+	return result
+}
+
+// A keyEntry is an accepted key of a keySet. Retired is nil unless the key
+// was removed from the JWKS.
+type keyEntry struct {
+	key     jose.JSONWebKey
+	retired *retiredKey
+}
+
+// A keySet holds the keys of a Provider which are accepted for token
+// signature verification, together with the status of all keys.
+type keySet struct {
+	entries []*keyEntry
+	status  []*KeyStatus
+}
+
+var emptyKeySet = &keySet{}
+
+// newKeySet creates a keySet from the provided JWKS, the provided extra
+// trusted keys and the provided retired keys, excluding all keys which do not
+// conform to the provided key policy. If pinned thumbprints are provided, all
+// keys whose thumbprint is not in the list are excluded as well.
+func newKeySet(jwks *jose.JSONWebKeySet, extraKeys []jose.JSONWebKey, retiredKeys []*retiredKey, policy *KeyPolicy, pinnedThumbprints []string) *keySet {
+	ks := &keySet{}
+	if jwks != nil {
+		for _, key := range jwks.Keys {
+			ks.add(key, KeySourceJWKS, nil, policy, pinnedThumbprints)
+		}
+	}
+	for _, key := range extraKeys {
+		ks.add(key, KeySourceExtra, nil, policy, pinnedThumbprints)
+	}
+	for _, rk := range retiredKeys {
+		ks.add(rk.key, KeySourceRetired, rk, policy, pinnedThumbprints)
+	}
+
+	return ks
+}
+
+func (ks *keySet) add(key jose.JSONWebKey, source string, retired *retiredKey, policy *KeyPolicy, pinnedThumbprints []string) {
+	keyType, curve, size := keyTypeCurveAndSize(key.Key)
+	status := &KeyStatus{
+		KeyID:      key.KeyID,
+		KeyType:    keyType,
+		Algorithm:  key.Algorithm,
+		Use:        key.Use,
+		Curve:      curve,
+		Size:       size,
+		Source:     source,
+		Thumbprint: keyThumbprint(&key),
+	}
+	if len(key.Certificates) > 0 {
+		status.CertificateSubject = key.Certificates[0].Subject.String()
+	}
+	if retired != nil {
+		status.RetiredAt = retired.retiredAt.Unix()
+		status.RetiredUntil = retired.expires.Unix()
+	}
+	if err := policy.Check(&key); err != nil {
+		status.Reason = err.Error()
+	} else if len(pinnedThumbprints) > 0 && !isStringInSlice(pinnedThumbprints, status.Thumbprint) {
+		status.Reason = "key not pinned"
+	} else {
+		status.Accepted = true
+		ks.entries = append(ks.entries, &keyEntry{
+			key:     key,
+			retired: retired,
+		})
+	}
+	ks.status = append(ks.status, status)
+}
+
+// lookup returns the accepted keys with the provided key ID which can be
+// used with the provided signing algorithm, where retired keys are only
+// returned if they are not expired at the provided time and after all other
+// keys. The second return value reports if keys with the provided key ID exist
+// at all, including rejected keys.
+func (ks *keySet) lookup(kid string, alg string, now time.Time) ([]*keyEntry, bool) {
+	var entries []*keyEntry
+	for _, entry := range ks.entries {
+		if entry.key.KeyID != kid {
+			continue
+		}
+		if keyType, _, _ := keyTypeCurveAndSize(entry.key.Key); keyType != keyTypeForSigningAlg(alg) {
+			continue
+		}
+		if entry.retired != nil && !now.Before(entry.retired.expires) {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) > 0 {
+		return entries, true
+	}
+
+	for _, status := range ks.status {
+		if status.KeyID == kid {
+			return nil, true
+		}
+	}
+
+	return nil, false
+}
+
+// selectKeyEntry returns the first of the provided candidates whose key
+// verifies the signature of the provided token. If none does, the first
+// candidate is returned so that the verification fails as usual.
+func selectKeyEntry(token *jwt.Token, candidates []*keyEntry) *keyEntry {
+	if len(candidates) == 1 {
+		return candidates[0]
+	}
+	parts := strings.Split(token.Raw, ".")
+	if len(parts) != 3 {
+		return candidates[0]
+	}
+	signingString := strings.Join(parts[0:2], ".")
+	for _, entry := range candidates {
+		if token.Method.Verify(signingString, parts[2], entry.key.Key) == nil {
+			return entry
+		}
+	}
+	return candidates[0]
+}
+
+// keyThumbprint returns the base64url encoded SHA-256 JWK thumbprint as
+// defined in RFC 7638 of the provided key, or an empty string if the key is
+// not supported.
+func keyThumbprint(key *jose.JSONWebKey) string {
+	thumbprint, err := key.Thumbprint(crypto.SHA256)
+	if err != nil {
+		return ""
+	}
+
+	return base64.RawURLEncoding.EncodeToString(thumbprint)
+}
+
+// LoadKeysFile loads public keys from the file with the provided name. The
+// file can either contain a JWK set, a single JWK, or one or more PEM encoded
+// public keys or certificates. Keys without key ID get their base64url encoded
+// SHA-256 JWK thumbprint as key ID, thus tokens must reference such keys with
+// the thumbprint in their kid header. Tokens without kid header never match
+// loaded keys.
+func LoadKeysFile(fn string) ([]jose.JSONWebKey, error) {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrStatusInvalidKey, err)
+	}
+
+	var keys []jose.JSONWebKey
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		keys, err = parseJWKSetOrKey(trimmed)
+	} else {
+		keys, err = parsePEMPublicKeys(data)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrStatusInvalidKey, err)
+	}
+	for idx := range keys {
+		key := &keys[idx]
+		if !key.IsPublic() {
+			return nil, fmt.Errorf("%w: key %s is not a public key", ErrStatusInvalidKey, key.KeyID)
+		}
+		if key.KeyID == "" {
+			key.KeyID = keyThumbprint(key)
+		}
+	}
+
+	return keys, nil
+}
+
+func parsePEMPublicKeys(data []byte) ([]jose.JSONWebKey, error) {
+	var keys []jose.JSONWebKey
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		var key interface{}
+		var certificates []*x509.Certificate
+		var err error
+		switch block.Type {
+		case "RSA PUBLIC KEY":
+			key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+		case "PUBLIC KEY":
+			key, err = x509.ParsePKIXPublicKey(block.Bytes)
+		case "CERTIFICATE":
+			var cert *x509.Certificate
+			cert, err = x509.ParseCertificate(block.Bytes)
+			if err == nil {
+				key = cert.PublicKey
+				certificates = []*x509.Certificate{cert}
+			}
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, jose.JSONWebKey{
+			Key:          key,
+			Certificates: certificates,
+		})
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no PEM public key found")
+	}
+
+	return keys, nil
+}
+
+func keyTypeCurveAndSize(key interface{}) (string, string, int) {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return KeyTypeRSA, "", k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return KeyTypeEC, k.Curve.Params().Name, k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return KeyTypeOKP, "Ed25519", len(k) * 8
+	}
+
+	return "", "", 0
+}
+
+func keyTypeForSigningAlg(alg string) string {
+	switch {
+	case strings.HasPrefix(alg, "RS"), strings.HasPrefix(alg, "PS"):
+		return KeyTypeRSA
+	case strings.HasPrefix(alg, "ES"):
+		return KeyTypeEC
+	case alg == SigningMethodEd25519.Alg():
+		return KeyTypeOKP
+	}
+
+	return ""
+}
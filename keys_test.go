@@ -0,0 +1,124 @@
+/*
+ * SPDX-License-Identifier: AGPL-3.0-or-later
+ * Copyright 2020 Kopano and its licensors
+ */
+
+package kcoidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/square/go-jose.v2"
+)
+
+func TestLoadKeysFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "kcoidc-keys-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "signer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, ecKey.Public(), ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkixDER, err := x509.MarshalPKIXPublicKey(rsaKey.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	marshalJSON := func(v interface{}) []byte {
+		data, marshalErr := json.Marshal(v)
+		if marshalErr != nil {
+			t.Fatal(marshalErr)
+		}
+		return data
+	}
+	rsaJWK := jose.JSONWebKey{Key: rsaKey.Public(), KeyID: "rsa", Algorithm: "RS256", Use: "sig"}
+	ecJWK := jose.JSONWebKey{Key: ecKey.Public(), Algorithm: "ES256", Use: "sig"}
+	ecThumbprint := keyThumbprint(&ecJWK)
+	rsaThumbprint := keyThumbprint(&jose.JSONWebKey{Key: rsaKey.Public()})
+
+	for _, tc := range []struct {
+		name   string
+		data   []byte
+		kids   []string
+		certs  int
+		failed bool
+	}{
+		{"jwks.json", marshalJSON(&jose.JSONWebKeySet{Keys: []jose.JSONWebKey{rsaJWK, ecJWK}}), []string{"rsa", ecThumbprint}, 0, false},
+		{"jwk.json", marshalJSON(&rsaJWK), []string{"rsa"}, 0, false},
+		{"private.json", marshalJSON(&jose.JSONWebKey{Key: rsaKey, KeyID: "private"}), nil, 0, true},
+		{"public.pem", pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkixDER}), []string{rsaThumbprint}, 0, false},
+		{"pkcs1.pem", pem.EncodeToMemory(&pem.Block{Type: "RSA PUBLIC KEY", Bytes: x509.MarshalPKCS1PublicKey(&rsaKey.PublicKey)}), []string{rsaThumbprint}, 0, false},
+		{"cert.pem", append(
+			pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certDER}),
+			pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pkixDER})...,
+		), []string{ecThumbprint, rsaThumbprint}, 1, false},
+		{"private.pem", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)}), nil, 0, true},
+		{"invalid.json", []byte(`{"keys":`), nil, 0, true},
+	} {
+		fn := filepath.Join(dir, tc.name)
+		if err = ioutil.WriteFile(fn, tc.data, 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		keys, loadErr := LoadKeysFile(fn)
+		if tc.failed {
+			if !errors.Is(loadErr, ErrStatusInvalidKey) {
+				t.Errorf("unexpected error for %s: %v", tc.name, loadErr)
+			}
+			continue
+		}
+		if loadErr != nil {
+			t.Errorf("unexpected error for %s: %v", tc.name, loadErr)
+			continue
+		}
+		if len(keys) != len(tc.kids) {
+			t.Errorf("unexpected number of keys for %s: %d", tc.name, len(keys))
+			continue
+		}
+		certs := 0
+		for idx, key := range keys {
+			if key.KeyID != tc.kids[idx] || !key.IsPublic() {
+				t.Errorf("unexpected key %d for %s: %v", idx, tc.name, key.KeyID)
+			}
+			certs += len(key.Certificates)
+		}
+		if certs != tc.certs {
+			t.Errorf("unexpected number of certificates for %s: %d", tc.name, certs)
+		}
+	}
+
+	if _, err = LoadKeysFile(filepath.Join(dir, "missing.pem")); !errors.Is(err, ErrStatusInvalidKey) {
+		t.Errorf("unexpected error for missing file: %v", err)
+	}
+}
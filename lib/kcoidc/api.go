@@ -7,7 +7,7 @@ package main //nolint
 
 /*
 #define KCOIDC_API 1
-#define KCOIDC_API_MINOR 2
+#define KCOIDC_API_MINOR 3
 
 #define KCOIDC_VERSION (KCOIDC_API * 10000 + KCOIDC_API_MINOR * 100)
 
@@ -17,12 +17,27 @@ package main //nolint
 #define KCOIDC_TOKEN_TYPE_STANDARD 0
 #define KCOIDC_TOKEN_TYPE_KCACCESS 1
 #define KCOIDC_TOKEN_TYPE_KCRERESH 2
+#define KCOIDC_TOKEN_TYPE_ACCESS 3
+
+// Token type mask bits, to be combined with | to define allowed token types.
+#define KCOIDC_TOKEN_TYPE_MASK(t) (1 << (t))
+
+// Guest policies as defined by kcoidc in guest_policy.go, made usable from C.
+#define KCOIDC_GUEST_POLICY_ALLOW 0
+#define KCOIDC_GUEST_POLICY_DENY 1
+#define KCOIDC_GUEST_POLICY_RESTRICT_SCOPES 2
+
+// Staleness policies as defined by kcoidc in staleness.go, made usable from C.
+#define KCOIDC_STALENESS_POLICY_ALLOW 0
+#define KCOIDC_STALENESS_POLICY_DENY 1
 */
 import "C" //nolint
 
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/openkop/libkcoidc" //nolint:goimports // False positive.
@@ -84,46 +99,303 @@ func kcoidc_insecure_skip_verify(enableInsecure C.int) C.ulonglong {
 	return kcoidc.StatusSuccess
 }
 
-//export kcoidc_validate_token_s
-func kcoidc_validate_token_s(tokenCString *C.char) (*C.char, C.ulonglong, C.int, *C.char, *C.char) {
+//export kcoidc_set_allowed_token_types
+func kcoidc_set_allowed_token_types(tokenTypesMask C.int) C.ulonglong {
+	err := SetAllowedTokenTypes(tokenTypesFromMask(int(tokenTypesMask)))
+	if err != nil {
+		return asKnownErrorOrUnknown(err)
+	}
+	return kcoidc.StatusSuccess
+}
+
+//export kcoidc_set_require_jwt_access_token_type
+func kcoidc_set_require_jwt_access_token_type(required C.int) C.ulonglong {
+	err := SetRequireJWTAccessTokenType(required == 1)
+	if err != nil {
+		return asKnownErrorOrUnknown(err)
+	}
+	return kcoidc.StatusSuccess
+}
+
+//export kcoidc_set_claims_profile
+func kcoidc_set_claims_profile(nameCString *C.char) C.ulonglong {
+	err := SetClaimsProfile(C.GoString(nameCString))
+	if err != nil {
+		return asKnownErrorOrUnknown(err)
+	}
+	return kcoidc.StatusSuccess
+}
+
+//export kcoidc_set_user_id_claim_paths
+func kcoidc_set_user_id_claim_paths(claimPathsCString *C.char) C.ulonglong {
+	err := SetUserIDClaimPaths(strings.Fields(C.GoString(claimPathsCString)))
+	if err != nil {
+		return asKnownErrorOrUnknown(err)
+	}
+	return kcoidc.StatusSuccess
+}
+
+//export kcoidc_set_role_claim_paths
+func kcoidc_set_role_claim_paths(claimPathsCString *C.char) C.ulonglong {
+	err := SetRoleClaimPaths(strings.Fields(C.GoString(claimPathsCString)))
+	if err != nil {
+		return asKnownErrorOrUnknown(err)
+	}
+	return kcoidc.StatusSuccess
+}
+
+//export kcoidc_set_policy_file
+func kcoidc_set_policy_file(fnCString *C.char) C.ulonglong {
+	err := SetPolicyFile(C.GoString(fnCString))
+	if err != nil {
+		return asKnownErrorOrUnknown(err)
+	}
+	return kcoidc.StatusSuccess
+}
+
+//export kcoidc_set_guest_policy
+func kcoidc_set_guest_policy(guestPolicy C.int, scopesCString *C.char) C.ulonglong {
+	err := SetGuestPolicy(int(guestPolicy), strings.Fields(C.GoString(scopesCString)))
+	if err != nil {
+		return asKnownErrorOrUnknown(err)
+	}
+	return kcoidc.StatusSuccess
+}
+
+//export kcoidc_set_signing_algs
+func kcoidc_set_signing_algs(idTokenAlgsCString *C.char, accessTokenAlgsCString *C.char, override C.int) C.ulonglong {
+	err := SetSigningAlgs(strings.Fields(C.GoString(idTokenAlgsCString)), strings.Fields(C.GoString(accessTokenAlgsCString)), override == 1)
+	if err != nil {
+		return asKnownErrorOrUnknown(err)
+	}
+	return kcoidc.StatusSuccess
+}
+
+//export kcoidc_set_key_policy
+func kcoidc_set_key_policy(minRSAKeySize C.int, allowedCurvesCString *C.char, allowedKeyTypesCString *C.char) C.ulonglong {
+	err := SetKeyPolicy(&kcoidc.KeyPolicy{
+		MinRSAKeySize:   int(minRSAKeySize),
+		AllowedCurves:   strings.Fields(C.GoString(allowedCurvesCString)),
+		AllowedKeyTypes: strings.Fields(C.GoString(allowedKeyTypesCString)),
+	})
+	if err != nil {
+		return asKnownErrorOrUnknown(err)
+	}
+	return kcoidc.StatusSuccess
+}
+
+//export kcoidc_set_key_ca_file
+func kcoidc_set_key_ca_file(fnCString *C.char) C.ulonglong {
+	err := SetKeyCertificateRootsFile(C.GoString(fnCString))
+	if err != nil {
+		return asKnownErrorOrUnknown(err)
+	}
+	return kcoidc.StatusSuccess
+}
+
+//export kcoidc_set_extra_keys_file
+func kcoidc_set_extra_keys_file(fnCString *C.char) C.ulonglong {
+	err := SetExtraKeysFile(C.GoString(fnCString))
+	if err != nil {
+		return asKnownErrorOrUnknown(err)
+	}
+	return kcoidc.StatusSuccess
+}
+
+//export kcoidc_set_pinned_keys
+func kcoidc_set_pinned_keys(thumbprintsCString *C.char) C.ulonglong {
+	err := SetPinnedKeys(strings.Fields(C.GoString(thumbprintsCString)))
+	if err != nil {
+		return asKnownErrorOrUnknown(err)
+	}
+	return kcoidc.StatusSuccess
+}
+
+//export kcoidc_set_key_grace_period
+func kcoidc_set_key_grace_period(gracePeriod C.ulonglong, maxTokenLifetime C.ulonglong) C.ulonglong {
+	err := SetKeyGracePeriod(time.Duration(gracePeriod)*time.Second, time.Duration(maxTokenLifetime)*time.Second)
+	if err != nil {
+		return asKnownErrorOrUnknown(err)
+	}
+	return kcoidc.StatusSuccess
+}
+
+//export kcoidc_set_staleness_policy
+func kcoidc_set_staleness_policy(maxStaleness C.ulonglong, stalenessPolicy C.int) C.ulonglong {
+	err := SetStalenessPolicy(time.Duration(maxStaleness)*time.Second, int(stalenessPolicy))
+	if err != nil {
+		return asKnownErrorOrUnknown(err)
+	}
+	return kcoidc.StatusSuccess
+}
+
+//export kcoidc_set_refresh_policy
+func kcoidc_set_refresh_policy(interval C.ulonglong, jitter C.ulonglong, minBackoff C.ulonglong, maxBackoff C.ulonglong) C.ulonglong {
+	err := SetRefreshPolicy(&kcoidc.RefreshPolicy{
+		Interval:   time.Duration(interval) * time.Second,
+		Jitter:     time.Duration(jitter) * time.Second,
+		MinBackoff: time.Duration(minBackoff) * time.Second,
+		MaxBackoff: time.Duration(maxBackoff) * time.Second,
+	})
+	if err != nil {
+		return asKnownErrorOrUnknown(err)
+	}
+	return kcoidc.StatusSuccess
+}
+
+//export kcoidc_set_initialize_retry
+func kcoidc_set_initialize_retry(retry C.int, minBackoff C.ulonglong, maxBackoff C.ulonglong) C.ulonglong {
+	err := SetInitializeRetry(retry != 0, time.Duration(minBackoff)*time.Second, time.Duration(maxBackoff)*time.Second)
+	if err != nil {
+		return asKnownErrorOrUnknown(err)
+	}
+	return kcoidc.StatusSuccess
+}
+
+//export kcoidc_set_decryption_key_file
+func kcoidc_set_decryption_key_file(fnCString *C.char) C.ulonglong {
+	err := SetDecryptionKeyFile(C.GoString(fnCString))
+	if err != nil {
+		return asKnownErrorOrUnknown(err)
+	}
+	return kcoidc.StatusSuccess
+}
+
+//export kcoidc_set_token_limits
+func kcoidc_set_token_limits(maxLength C.int, maxClaimsDepth C.int) C.ulonglong {
+	err := SetTokenLimits(int(maxLength), int(maxClaimsDepth))
+	if err != nil {
+		return asKnownErrorOrUnknown(err)
+	}
+	return kcoidc.StatusSuccess
+}
+
+// validationResultValues returns the authenticated user ID, the token type,
+// the JSON encoded standard and extra claims and the guest flag of the
+// provided result as returned by the validate functions.
+func validationResultValues(result *kcoidc.ValidationResult) (*C.char, C.int, *C.char, *C.char, C.int) {
 	var standardClaimsBytes []byte
 	var extraClaimsBytes []byte
+	var guest int
 	tokenType := kcoidc.TokenTypeStandard
-	subject, standardClaims, extraClaims, err := ValidateTokenString(C.GoString(tokenCString))
-	if standardClaims != nil {
+	if result.StandardClaims != nil {
 		// Encode to JSON
-		standardClaimsBytes, _ = json.Marshal(standardClaims)
+		standardClaimsBytes, _ = json.Marshal(result.StandardClaims)
 	}
-	if extraClaims != nil {
+	if result.ExtraClaims != nil {
 		// Encode to JSON
-		extraClaimsBytes, _ = json.Marshal(extraClaims)
-		tokenType = extraClaims.KCTokenType()
+		extraClaimsBytes, _ = json.Marshal(result.ExtraClaims)
+		// Use the token type of the result, which includes the typ header.
+		tokenType = result.TokenType
+		if IsGuest(result.ExtraClaims) {
+			guest = 1
+		}
+	}
+
+	return C.CString(result.AuthenticatedUserID), C.int(tokenType), C.CString(string(standardClaimsBytes)), C.CString(string(extraClaimsBytes)), C.int(guest)
+}
+
+// NOTE: The return values of kcoidc_validate_token_s are kept for compatibility
+// with API 1.2 and do not include the guest flag. Use
+// kcoidc_validate_token_ex_s to get it.
+
+//export kcoidc_validate_token_s
+func kcoidc_validate_token_s(tokenCString *C.char) (*C.char, C.ulonglong, C.int, *C.char, *C.char) {
+	result, err := ValidateToken(C.GoString(tokenCString))
+	subject, tokenType, standardClaims, extraClaims, _ := validationResultValues(result)
+	if err != nil {
+		return subject, asKnownErrorOrUnknown(err), tokenType, standardClaims, extraClaims
+	}
+	return subject, kcoidc.StatusSuccess, tokenType, standardClaims, extraClaims
+}
+
+//export kcoidc_validate_token_ex_s
+func kcoidc_validate_token_ex_s(tokenCString *C.char, allowedTokenTypesMask C.int) (*C.char, C.ulonglong) {
+	result, err := ValidateTokenEx(C.GoString(tokenCString), kcoidc.WithAllowedTokenTypes(tokenTypesFromMask(int(allowedTokenTypesMask))...))
+
+	// Encode to JSON
+	res, marshalErr := json.Marshal(result)
+	if err == nil {
+		err = marshalErr
 	}
 	if err != nil {
-		return C.CString(subject), asKnownErrorOrUnknown(err), C.int(tokenType), C.CString(string(standardClaimsBytes)), C.CString(string(extraClaimsBytes))
+		return C.CString(string(res)), asKnownErrorOrUnknown(err)
 	}
-	return C.CString(subject), kcoidc.StatusSuccess, C.int(tokenType), C.CString(string(standardClaimsBytes)), C.CString(string(extraClaimsBytes))
+	return C.CString(string(res)), kcoidc.StatusSuccess
 }
 
+// NOTE: The return values of kcoidc_validate_token_and_require_scope_s are
+// kept for compatibility with API 1.2 and do not include the guest flag. Use
+// kcoidc_validate_token_ex_s to get it.
+
 //export kcoidc_validate_token_and_require_scope_s
 func kcoidc_validate_token_and_require_scope_s(tokenCString *C.char, requiredScopeCString *C.char) (*C.char, C.ulonglong, C.int, *C.char, *C.char) {
-	var standardClaimsBytes []byte
-	var extraClaimsBytes []byte
-	tokenType := kcoidc.TokenTypeStandard
-	subject, standardClaims, extraClaims, err := ValidateTokenStringAndRequireClaim(C.GoString(tokenCString), C.GoString(requiredScopeCString))
-	if standardClaims != nil {
-		// Encode to JSON
-		standardClaimsBytes, _ = json.Marshal(standardClaims)
+	result, err := ValidateTokenAndRequireClaim(C.GoString(tokenCString), C.GoString(requiredScopeCString))
+	subject, tokenType, standardClaims, extraClaims, _ := validationResultValues(result)
+	if err != nil {
+		return subject, asKnownErrorOrUnknown(err), tokenType, standardClaims, extraClaims
 	}
-	if extraClaims != nil {
-		// Encode to JSON
-		extraClaimsBytes, _ = json.Marshal(extraClaims)
-		tokenType = extraClaims.KCTokenType()
+	return subject, kcoidc.StatusSuccess, tokenType, standardClaims, extraClaims
+}
+
+//export kcoidc_validate_token_and_require_scopes_s
+func kcoidc_validate_token_and_require_scopes_s(tokenCString *C.char, requiredScopesCString *C.char) (*C.char, C.ulonglong, C.int, *C.char, *C.char, *C.char, C.int) {
+	var missingScopes []string
+	result, err := ValidateTokenAndRequireScopes(C.GoString(tokenCString), strings.Fields(C.GoString(requiredScopesCString)))
+	subject, tokenType, standardClaims, extraClaims, guest := validationResultValues(result)
+	var missingScopesErr *kcoidc.MissingScopesError
+	if errors.As(err, &missingScopesErr) {
+		missingScopes = missingScopesErr.Scopes
 	}
 	if err != nil {
-		return C.CString(subject), asKnownErrorOrUnknown(err), C.int(tokenType), C.CString(string(standardClaimsBytes)), C.CString(string(extraClaimsBytes))
+		return subject, asKnownErrorOrUnknown(err), tokenType, standardClaims, extraClaims, C.CString(strings.Join(missingScopes, " ")), guest
 	}
-	return C.CString(subject), kcoidc.StatusSuccess, C.int(tokenType), C.CString(string(standardClaimsBytes)), C.CString(string(extraClaimsBytes))
+	return subject, kcoidc.StatusSuccess, tokenType, standardClaims, extraClaims, C.CString(strings.Join(missingScopes, " ")), guest
+}
+
+//export kcoidc_validate_token_and_require_authorized_claims_s
+func kcoidc_validate_token_and_require_authorized_claims_s(tokenCString *C.char, claimsRequestCString *C.char) (*C.char, C.ulonglong, C.int, *C.char, *C.char, *C.char, C.int) {
+	var missingClaims []string
+	result, err := ValidateTokenAndRequireAuthorizedClaims(C.GoString(tokenCString), C.GoString(claimsRequestCString))
+	subject, tokenType, standardClaims, extraClaims, guest := validationResultValues(result)
+	var missingClaimsErr *kcoidc.MissingClaimsError
+	if errors.As(err, &missingClaimsErr) {
+		missingClaims = missingClaimsErr.Claims
+	}
+	if err != nil {
+		return subject, asKnownErrorOrUnknown(err), tokenType, standardClaims, extraClaims, C.CString(strings.Join(missingClaims, " ")), guest
+	}
+	return subject, kcoidc.StatusSuccess, tokenType, standardClaims, extraClaims, C.CString(strings.Join(missingClaims, " ")), guest
+}
+
+//export kcoidc_validate_token_and_require_role_s
+func kcoidc_validate_token_and_require_role_s(tokenCString *C.char, requiredRoleCString *C.char) (*C.char, C.ulonglong, C.int, *C.char, *C.char, C.int) {
+	result, err := ValidateTokenAndRequireRole(C.GoString(tokenCString), C.GoString(requiredRoleCString))
+	subject, tokenType, standardClaims, extraClaims, guest := validationResultValues(result)
+	if err != nil {
+		return subject, asKnownErrorOrUnknown(err), tokenType, standardClaims, extraClaims, guest
+	}
+	return subject, kcoidc.StatusSuccess, tokenType, standardClaims, extraClaims, guest
+}
+
+//export kcoidc_validate_dpop_token_s
+func kcoidc_validate_dpop_token_s(tokenCString *C.char, proofCString *C.char, methodCString *C.char, uriCString *C.char) (*C.char, C.ulonglong, C.int, *C.char, *C.char, C.int) {
+	result, err := ValidateDPoPToken(C.GoString(tokenCString), C.GoString(proofCString), C.GoString(methodCString), C.GoString(uriCString))
+	subject, tokenType, standardClaims, extraClaims, guest := validationResultValues(result)
+	if err != nil {
+		return subject, asKnownErrorOrUnknown(err), tokenType, standardClaims, extraClaims, guest
+	}
+	return subject, kcoidc.StatusSuccess, tokenType, standardClaims, extraClaims, guest
+}
+
+//export kcoidc_validate_certificate_bound_token_s
+func kcoidc_validate_certificate_bound_token_s(tokenCString *C.char, certPEMCString *C.char) (*C.char, C.ulonglong, C.int, *C.char, *C.char, C.int) {
+	result, err := ValidateCertificateBoundToken(C.GoString(tokenCString), C.GoString(certPEMCString))
+	subject, tokenType, standardClaims, extraClaims, guest := validationResultValues(result)
+	if err != nil {
+		return subject, asKnownErrorOrUnknown(err), tokenType, standardClaims, extraClaims, guest
+	}
+	return subject, kcoidc.StatusSuccess, tokenType, standardClaims, extraClaims, guest
 }
 
 //export kcoidc_fetch_userinfo_with_accesstoken_s
@@ -142,6 +414,54 @@ func kcoidc_fetch_userinfo_with_accesstoken_s(tokenCString *C.char) (*C.char, C.
 	return C.CString(string(res)), kcoidc.StatusSuccess
 }
 
+//export kcoidc_konnect_identity_from_claims_s
+func kcoidc_konnect_identity_from_claims_s(extraClaimsCString *C.char) (*C.char, C.ulonglong) {
+	identity, err := KonnectIdentityFromClaimsJSON(C.GoString(extraClaimsCString))
+	if err != nil {
+		return nil, asKnownErrorOrUnknown(err)
+	}
+
+	// Encode to JSON
+	res, err := json.Marshal(identity)
+	if err != nil {
+		return nil, asKnownErrorOrUnknown(err)
+	}
+
+	return C.CString(string(res)), kcoidc.StatusSuccess
+}
+
+//export kcoidc_status_s
+func kcoidc_status_s() (*C.char, C.ulonglong) {
+	status, err := Status()
+	if err != nil {
+		return nil, asKnownErrorOrUnknown(err)
+	}
+
+	// Encode to JSON
+	res, err := json.Marshal(status)
+	if err != nil {
+		return nil, asKnownErrorOrUnknown(err)
+	}
+
+	return C.CString(string(res)), kcoidc.StatusSuccess
+}
+
+//export kcoidc_refresh
+func kcoidc_refresh() (*C.char, C.ulonglong) {
+	result, err := Refresh()
+	if err != nil {
+		return nil, asKnownErrorOrUnknown(err)
+	}
+
+	// Encode to JSON
+	res, err := json.Marshal(result)
+	if err != nil {
+		return nil, asKnownErrorOrUnknown(err)
+	}
+
+	return C.CString(string(res)), kcoidc.StatusSuccess
+}
+
 //export kcoidc_uninitialize
 func kcoidc_uninitialize() C.ulonglong {
 	err := Uninitialize()
@@ -7,19 +7,20 @@ package main
 
 import (
 	"C"
+	"errors"
 	"fmt"
 
 	"github.com/openkop/libkcoidc"
 )
 
 func asKnownErrorOrUnknown(err error) C.ulonglong {
-	switch e := err.(type) {
-	case kcoidc.ErrStatus:
-		return C.ulonglong(e)
-	default:
-		if debug {
-			fmt.Printf("kcoidc-c unknown error: %s\n", err)
-		}
-		return C.ulonglong(kcoidc.ErrStatusUnknown)
+	var errStatus kcoidc.ErrStatus
+	if errors.As(err, &errStatus) {
+		return C.ulonglong(errStatus)
 	}
+
+	if debug {
+		fmt.Printf("kcoidc-c unknown error: %s\n", err)
+	}
+	return C.ulonglong(kcoidc.ErrStatusUnknown)
 }
@@ -9,16 +9,21 @@ import (
 	"C"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
 	"os"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"golang.org/x/net/http2"
+	"gopkg.in/square/go-jose.v2"
 
 	"github.com/openkop/libkcoidc"
 	"github.com/openkop/libkcoidc/internal/version"
@@ -38,6 +43,34 @@ var (
 
 	initializedLogger kcoidc.Logger
 	provider          *kcoidc.Provider
+
+	allowedTokenTypes         []int
+	requireJWTAccessTokenType bool
+	claimsProfile             = kcoidc.DefaultClaimsProfile
+	userIDClaimPaths          []string
+	roleClaimPaths            []string
+	policy                    *kcoidc.Policy
+	guestPolicy               = kcoidc.GuestPolicyAllow
+	guestScopes               []string
+
+	idTokenSigningAlgs           []string
+	accessTokenSigningAlgs       []string
+	signingAlgsOverrideDiscovery bool
+	keyPolicy                    *kcoidc.KeyPolicy
+	keyCertificateRoots          *x509.CertPool
+	extraKeys                    []jose.JSONWebKey
+	pinnedThumbprints            []string
+	keyGracePeriod               time.Duration
+	maxTokenLifetime             time.Duration
+	maxStaleness                 time.Duration
+	stalenessPolicy              = kcoidc.StalenessPolicyAllow
+	refreshPolicy                *kcoidc.RefreshPolicy
+	initializeRetry              = true
+	initializeMinBackoff         time.Duration
+	initializeMaxBackoff         time.Duration
+	decryptionKeys               []jose.JSONWebKey
+	maxTokenLength               int
+	maxClaimsDepth               int
 )
 
 func init() {
@@ -137,6 +170,150 @@ func Initialize(ctx context.Context, iss string) error {
 		return err
 	}
 
+	err = p.SetAllowedTokenTypes(allowedTokenTypes...)
+	if err != nil {
+		if debug {
+			fmt.Printf("kcoidc-c initialize failed to set allowed token types: %v\n", err)
+		}
+		return err
+	}
+
+	err = p.SetRequireJWTAccessTokenType(requireJWTAccessTokenType)
+	if err != nil {
+		if debug {
+			fmt.Printf("kcoidc-c initialize failed to set require JWT access token type: %v\n", err)
+		}
+		return err
+	}
+
+	err = p.SetClaimsProfile(claimsProfile)
+	if err != nil {
+		if debug {
+			fmt.Printf("kcoidc-c initialize failed to set claims profile: %v\n", err)
+		}
+		return err
+	}
+
+	err = p.SetUserIDClaimPaths(userIDClaimPaths...)
+	if err != nil {
+		if debug {
+			fmt.Printf("kcoidc-c initialize failed to set user ID claim paths: %v\n", err)
+		}
+		return err
+	}
+
+	err = p.SetRoleClaimPaths(roleClaimPaths...)
+	if err != nil {
+		if debug {
+			fmt.Printf("kcoidc-c initialize failed to set role claim paths: %v\n", err)
+		}
+		return err
+	}
+
+	err = p.SetPolicy(policy)
+	if err != nil {
+		if debug {
+			fmt.Printf("kcoidc-c initialize failed to set policy: %v\n", err)
+		}
+		return err
+	}
+
+	err = p.SetGuestPolicy(guestPolicy, guestScopes...)
+	if err != nil {
+		if debug {
+			fmt.Printf("kcoidc-c initialize failed to set guest policy: %v\n", err)
+		}
+		return err
+	}
+
+	err = p.SetSigningAlgs(idTokenSigningAlgs, accessTokenSigningAlgs, signingAlgsOverrideDiscovery)
+	if err != nil {
+		if debug {
+			fmt.Printf("kcoidc-c initialize failed to set signing algs: %v\n", err)
+		}
+		return err
+	}
+
+	policy := keyPolicy
+	if keyCertificateRoots != nil {
+		policy = &kcoidc.KeyPolicy{}
+		if keyPolicy != nil {
+			*policy = *keyPolicy
+		}
+		policy.CertificateRoots = keyCertificateRoots
+	}
+	err = p.SetKeyPolicy(policy)
+	if err != nil {
+		if debug {
+			fmt.Printf("kcoidc-c initialize failed to set key policy: %v\n", err)
+		}
+		return err
+	}
+
+	err = p.SetExtraKeys(extraKeys...)
+	if err != nil {
+		if debug {
+			fmt.Printf("kcoidc-c initialize failed to set extra keys: %v\n", err)
+		}
+		return err
+	}
+
+	err = p.SetPinnedKeys(pinnedThumbprints...)
+	if err != nil {
+		if debug {
+			fmt.Printf("kcoidc-c initialize failed to set pinned keys: %v\n", err)
+		}
+		return err
+	}
+
+	err = p.SetKeyGracePeriod(keyGracePeriod, maxTokenLifetime)
+	if err != nil {
+		if debug {
+			fmt.Printf("kcoidc-c initialize failed to set key grace period: %v\n", err)
+		}
+		return err
+	}
+
+	err = p.SetStalenessPolicy(maxStaleness, stalenessPolicy)
+	if err != nil {
+		if debug {
+			fmt.Printf("kcoidc-c initialize failed to set staleness policy: %v\n", err)
+		}
+		return err
+	}
+
+	err = p.SetRefreshPolicy(refreshPolicy)
+	if err != nil {
+		if debug {
+			fmt.Printf("kcoidc-c initialize failed to set refresh policy: %v\n", err)
+		}
+		return err
+	}
+
+	err = p.SetDecryptionKeys(decryptionKeys...)
+	if err != nil {
+		if debug {
+			fmt.Printf("kcoidc-c initialize failed to set decryption keys: %v\n", err)
+		}
+		return err
+	}
+
+	err = p.SetTokenLimits(maxTokenLength, maxClaimsDepth)
+	if err != nil {
+		if debug {
+			fmt.Printf("kcoidc-c initialize failed to set token limits: %v\n", err)
+		}
+		return err
+	}
+
+	err = p.SetInitializeRetry(initializeRetry, initializeMinBackoff, initializeMaxBackoff)
+	if err != nil {
+		if debug {
+			fmt.Printf("kcoidc-c initialize failed to set initialize retry: %v\n", err)
+		}
+		return err
+	}
+
 	err = p.Initialize(ctx, issURL)
 	if err != nil {
 		if debug {
@@ -182,6 +359,309 @@ func Uninitialize() error {
 	return nil
 }
 
+// SetAllowedTokenTypes sets the token types which are accepted when validating
+// tokens. It must be called before the call to initialize.
+func SetAllowedTokenTypes(tokenTypes []int) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if provider != nil {
+		return kcoidc.ErrStatusAlreadyInitialized
+	}
+	allowedTokenTypes = tokenTypes
+	return nil
+}
+
+// SetRequireJWTAccessTokenType sets if only tokens with the typ header of JWT
+// access tokens as defined in RFC 9068 are accepted when validating tokens. It
+// must be called before the call to initialize.
+func SetRequireJWTAccessTokenType(required bool) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if provider != nil {
+		return kcoidc.ErrStatusAlreadyInitialized
+	}
+	requireJWTAccessTokenType = required
+	return nil
+}
+
+// SetClaimsProfile sets the built-in claims profile with the provided name to
+// derive values from token claims. It must be called before the call to
+// initialize.
+func SetClaimsProfile(name string) error {
+	profile, err := kcoidc.ClaimsProfileByName(name)
+	if err != nil {
+		return err
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if provider != nil {
+		return kcoidc.ErrStatusAlreadyInitialized
+	}
+	claimsProfile = profile
+	return nil
+}
+
+// SetUserIDClaimPaths sets the ordered claim paths which are used to derive
+// the authenticated user ID. It must be called before the call to initialize.
+func SetUserIDClaimPaths(claimPaths []string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if provider != nil {
+		return kcoidc.ErrStatusAlreadyInitialized
+	}
+	userIDClaimPaths = claimPaths
+	return nil
+}
+
+// SetRoleClaimPaths sets the claim paths which are used to look up roles and
+// groups. It must be called before the call to initialize.
+func SetRoleClaimPaths(claimPaths []string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if provider != nil {
+		return kcoidc.ErrStatusAlreadyInitialized
+	}
+	roleClaimPaths = claimPaths
+	return nil
+}
+
+// SetPolicyFile loads the claims policy from the JSON file with the provided
+// name, to be evaluated when validating tokens. It must be called before the
+// call to initialize.
+func SetPolicyFile(fn string) error {
+	p, err := kcoidc.LoadPolicyFile(fn)
+	if err != nil {
+		if debug {
+			fmt.Printf("kcoidc-c set policy file failed: %v\n", err)
+		}
+		return err
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if provider != nil {
+		return kcoidc.ErrStatusAlreadyInitialized
+	}
+	policy = p
+	return nil
+}
+
+// SetGuestPolicy sets how tokens of guest identities are treated when
+// validating tokens. With kcoidc.GuestPolicyRestrictScopes, guest tokens are
+// accepted with only those of their authorized scopes which are included in
+// the provided scopes. It must be called before the call to initialize.
+func SetGuestPolicy(policy int, scopes []string) error {
+	switch policy {
+	case kcoidc.GuestPolicyAllow, kcoidc.GuestPolicyDeny, kcoidc.GuestPolicyRestrictScopes:
+	default:
+		return kcoidc.ErrStatusInvalidGuestPolicy
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if provider != nil {
+		return kcoidc.ErrStatusAlreadyInitialized
+	}
+	guestPolicy = policy
+	guestScopes = scopes
+	return nil
+}
+
+// SetSigningAlgs sets the signing algorithms which are accepted for ID tokens
+// and for access tokens, either intersected with or overriding the algorithms
+// of the discovery document. It must be called before the call to initialize.
+func SetSigningAlgs(idTokenAlgs, accessTokenAlgs []string, override bool) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if provider != nil {
+		return kcoidc.ErrStatusAlreadyInitialized
+	}
+	idTokenSigningAlgs = idTokenAlgs
+	accessTokenSigningAlgs = accessTokenAlgs
+	signingAlgsOverrideDiscovery = override
+	return nil
+}
+
+// SetKeyPolicy sets the key policy which defines the requirements for keys to
+// be used for token signature verification. It must be called before the call
+// to initialize.
+func SetKeyPolicy(policy *kcoidc.KeyPolicy) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if provider != nil {
+		return kcoidc.ErrStatusAlreadyInitialized
+	}
+	keyPolicy = policy
+	return nil
+}
+
+// SetKeyCertificateRootsFile loads the PEM encoded CA certificates from the
+// file with the provided name. If set, keys are only accepted with a x5c
+// certificate chain which validates against these CA certificates. It must be
+// called before the call to initialize.
+func SetKeyCertificateRootsFile(fn string) error {
+	roots, err := kcoidc.LoadCertificateRootsFile(fn)
+	if err != nil {
+		if debug {
+			fmt.Printf("kcoidc-c set key certificate roots file failed: %v\n", err)
+		}
+		return err
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if provider != nil {
+		return kcoidc.ErrStatusAlreadyInitialized
+	}
+	keyCertificateRoots = roots
+	return nil
+}
+
+// SetExtraKeysFile loads additional trusted public keys from the PEM or JWK set
+// file with the provided name. It must be called before the call to
+// initialize.
+func SetExtraKeysFile(fn string) error {
+	keys, err := kcoidc.LoadKeysFile(fn)
+	if err != nil {
+		if debug {
+			fmt.Printf("kcoidc-c set extra keys file failed: %v\n", err)
+		}
+		return err
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if provider != nil {
+		return kcoidc.ErrStatusAlreadyInitialized
+	}
+	extraKeys = keys
+	return nil
+}
+
+// SetPinnedKeys sets the JWK thumbprints of the keys which are exclusively
+// accepted for token signature verification. It must be called before the
+// call to initialize.
+func SetPinnedKeys(thumbprints []string) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if provider != nil {
+		return kcoidc.ErrStatusAlreadyInitialized
+	}
+	pinnedThumbprints = thumbprints
+	return nil
+}
+
+// SetKeyGracePeriod sets the duration for which keys which were removed from
+// the JWKS are still accepted, bounded by the provided max token lifetime. It
+// must be called before the call to initialize.
+func SetKeyGracePeriod(gracePeriod time.Duration, maxLifetime time.Duration) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if provider != nil {
+		return kcoidc.ErrStatusAlreadyInitialized
+	}
+	keyGracePeriod = gracePeriod
+	maxTokenLifetime = maxLifetime
+	return nil
+}
+
+// SetStalenessPolicy sets for how long the last known good provider
+// definition is used when refreshing fails and the behavior once that is
+// exceeded. It must be called before the call to initialize.
+func SetStalenessPolicy(max time.Duration, policy int) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if provider != nil {
+		return kcoidc.ErrStatusAlreadyInitialized
+	}
+	maxStaleness = max
+	stalenessPolicy = policy
+	return nil
+}
+
+// SetRefreshPolicy sets the interval, jitter and backoff of the refreshes of
+// the discovery document and JWKS. Without interval, refreshes are scheduled
+// based on HTTP caching. It must be called before the call to initialize.
+func SetRefreshPolicy(policy *kcoidc.RefreshPolicy) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if provider != nil {
+		return kcoidc.ErrStatusAlreadyInitialized
+	}
+	refreshPolicy = policy
+	return nil
+}
+
+// SetInitializeRetry sets if initialize keeps retrying in the background with
+// exponential backoff between the provided minimum and maximum when the
+// provider cannot be reached. Retrying is enabled by default, disable it to
+// make initialize fail fast. It must be called before the call to initialize.
+func SetInitializeRetry(retry bool, minBackoff time.Duration, maxBackoff time.Duration) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if provider != nil {
+		return kcoidc.ErrStatusAlreadyInitialized
+	}
+	initializeRetry = retry
+	initializeMinBackoff = minBackoff
+	initializeMaxBackoff = maxBackoff
+	return nil
+}
+
+// SetDecryptionKeyFile loads the private keys to decrypt encrypted tokens from
+// the PEM or JWK set file with the provided name. It must be called before the
+// call to initialize.
+func SetDecryptionKeyFile(fn string) error {
+	keys, err := kcoidc.LoadDecryptionKeysFile(fn)
+	if err != nil {
+		if debug {
+			fmt.Printf("kcoidc-c set decryption key file failed: %v\n", err)
+		}
+		return err
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if provider != nil {
+		return kcoidc.ErrStatusAlreadyInitialized
+	}
+	decryptionKeys = keys
+	return nil
+}
+
+// SetTokenLimits sets the maximum length of tokens and the maximum nesting
+// depth of token claims. Zero values select the defaults. It must be called
+// before the call to initialize.
+func SetTokenLimits(maxLength int, maxDepth int) error {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if provider != nil {
+		return kcoidc.ErrStatusAlreadyInitialized
+	}
+	maxTokenLength = maxLength
+	maxClaimsDepth = maxDepth
+	return nil
+}
+
 // InsecureSkipVerify sets up the libraries HTTP transport according to the
 // provided parametters.
 func InsecureSkipVerify(insecureSkipVerify bool) error {
@@ -236,7 +716,7 @@ func WaitUntilReady(timeout time.Duration) error {
 // ValidateTokenString validates the provided token string value and returns
 // the authenticated users ID as found the claims the standard claims and all
 // extra claims. Error will be set when the validation failed.
-func ValidateTokenString(tokenString string) (string, *jwt.StandardClaims, *kcoidc.ExtraClaimsWithType, error) {
+func ValidateTokenString(tokenString string, opts ...kcoidc.ValidateOption) (string, *jwt.StandardClaims, *kcoidc.ExtraClaimsWithType, error) {
 	mutex.RLock()
 	p := provider
 	ctx := initializedContext
@@ -249,30 +729,234 @@ func ValidateTokenString(tokenString string) (string, *jwt.StandardClaims, *kcoi
 		return "", nil, nil, kcoidc.ErrStatusNotInitialized
 	}
 
-	authenticatedUserID, standardClaims, extraClaims, err := p.ValidateTokenString(ctx, tokenString)
+	authenticatedUserID, standardClaims, extraClaims, err := p.ValidateTokenString(ctx, tokenString, opts...)
 	if err != nil && debug {
 		fmt.Printf("kcoidc-c validate token resulted in validation failure: %s\n", err)
 	}
 	return authenticatedUserID, standardClaims, extraClaims, err
 }
 
-// ValidateTokenStringAndRequireClaim validates the provided token string value
-//  and returns the authenticated users ID as found the claims the standard
-// claims and all extra claims. In addition, the token must have authenticated
-// the provided requiredScope. Error will be set when the validation failed or
-// the required scope is not authenticated.
-func ValidateTokenStringAndRequireClaim(tokenString string, requiredScope string) (string, *jwt.StandardClaims, *kcoidc.ExtraClaimsWithType, error) {
-	authenticatedUserID, standardClaims, extraClaims, err := ValidateTokenString(tokenString)
+// ValidateToken validates the provided token string value like
+// ValidateTokenString and returns the full validation result, including the
+// JOSE header and all original claims. Error will be set when the validation
+// failed.
+func ValidateToken(tokenString string, opts ...kcoidc.ValidateOption) (*kcoidc.ValidationResult, error) {
+	mutex.RLock()
+	p := provider
+	ctx := initializedContext
+	mutex.RUnlock()
+
+	if debug {
+		fmt.Printf("kcoidc-c validate token: %s\n", tokenString)
+	}
+	if p == nil {
+		return &kcoidc.ValidationResult{}, kcoidc.ErrStatusNotInitialized
+	}
+
+	result, err := p.ValidateToken(ctx, tokenString, opts...)
+	if err != nil && debug {
+		fmt.Printf("kcoidc-c validate token resulted in validation failure: %s\n", err)
+	}
+	return result, err
+}
+
+// A ValidationResult is the result of ValidateTokenEx as returned JSON encoded
+// by kcoidc_validate_token_ex_s.
+type ValidationResult struct {
+	AuthenticatedUserID string `json:"authenticated_user_id"`
+	UserIDClaimPath     string `json:"user_id_claim_path,omitempty"`
+	TokenType           int    `json:"token_type"`
+	Guest               bool   `json:"guest"`
+	Encrypted           bool   `json:"encrypted"`
+
+	Header         map[string]interface{}      `json:"header,omitempty"`
+	Claims         map[string]interface{}      `json:"claims,omitempty"`
+	StandardClaims *jwt.StandardClaims         `json:"standard_claims,omitempty"`
+	ExtraClaims    *kcoidc.ExtraClaimsWithType `json:"extra_claims,omitempty"`
+}
+
+// ValidateTokenEx validates the provided token string value like ValidateToken
+// and returns all results in a single ValidationResult, including the claim
+// path of the authenticated user ID and the guest flag. The returned
+// ValidationResult is never nil, even if validation failed.
+func ValidateTokenEx(tokenString string, opts ...kcoidc.ValidateOption) (*ValidationResult, error) {
+	result, err := ValidateToken(tokenString, opts...)
+
+	exResult := &ValidationResult{
+		AuthenticatedUserID: result.AuthenticatedUserID,
+		TokenType:           result.TokenType,
+		Encrypted:           result.Encrypted,
+		Header:              result.Header,
+		Claims:              result.Claims,
+		StandardClaims:      result.StandardClaims,
+		ExtraClaims:         result.ExtraClaims,
+	}
+	if result.ExtraClaims != nil {
+		exResult.UserIDClaimPath = AuthenticatedUserIDClaimPath(result.StandardClaims, result.ExtraClaims)
+		exResult.Guest = IsGuest(result.ExtraClaims)
+	}
+	return exResult, err
+}
+
+// ValidateTokenAndRequireClaim validates the provided token string value like
+// ValidateToken. In addition, the token must have authenticated the provided
+// requiredScope. Error will be set when the validation failed or the required
+// scope is not authenticated.
+func ValidateTokenAndRequireClaim(tokenString string, requiredScope string) (*kcoidc.ValidationResult, error) {
+	result, err := ValidateToken(tokenString)
 	if err != nil {
-		return authenticatedUserID, standardClaims, extraClaims, err
+		return result, err
 	}
 
-	err = kcoidc.RequireScopesInClaims(extraClaims, []string{requiredScope})
+	err = kcoidc.RequireScopesInClaimsWithProfile(ClaimsProfile(), result.ExtraClaims, []string{requiredScope})
 	if err != nil && debug {
 		fmt.Printf("kcoidc-c validate token and require claims result in scope require failure: %s\n", err)
 	}
 
-	return authenticatedUserID, standardClaims, extraClaims, err
+	return result, err
+}
+
+// ValidateTokenAndRequireScopes validates the provided token string value like
+// ValidateToken. In addition, the token must have authenticated all the
+// provided requiredScopes. Error will be set when the validation failed or any
+// required scope is not authenticated.
+func ValidateTokenAndRequireScopes(tokenString string, requiredScopes []string) (*kcoidc.ValidationResult, error) {
+	result, err := ValidateToken(tokenString)
+	if err != nil {
+		return result, err
+	}
+
+	err = kcoidc.RequireScopesInClaimsWithProfile(ClaimsProfile(), result.ExtraClaims, requiredScopes)
+	if err != nil && debug {
+		fmt.Printf("kcoidc-c validate token and require scopes result in scope require failure: %s\n", err)
+	}
+
+	return result, err
+}
+
+// ValidateTokenAndRequireAuthorizedClaims validates the provided token string
+// value like ValidateToken. In addition, the token must have authorized all
+// the claims of the provided JSON claims request. Error will be set when the
+// validation failed or any requested claim is not authorized.
+func ValidateTokenAndRequireAuthorizedClaims(tokenString string, claimsRequest string) (*kcoidc.ValidationResult, error) {
+	requiredClaims, err := kcoidc.ParseClaimsRequest([]byte(claimsRequest))
+	if err != nil {
+		return &kcoidc.ValidationResult{}, err
+	}
+
+	result, err := ValidateToken(tokenString)
+	if err != nil {
+		return result, err
+	}
+
+	err = kcoidc.RequireAuthorizedClaims(result.ExtraClaims, requiredClaims)
+	if err != nil && debug {
+		fmt.Printf("kcoidc-c validate token and require authorized claims result in claims require failure: %s\n", err)
+	}
+
+	return result, err
+}
+
+// ValidateTokenAndRequireRole validates the provided token string value like
+// ValidateToken. In addition, the token must carry the provided requiredRole.
+// Error will be set when the validation failed or the required role is not
+// found.
+func ValidateTokenAndRequireRole(tokenString string, requiredRole string) (*kcoidc.ValidationResult, error) {
+	result, err := ValidateToken(tokenString)
+	if err != nil {
+		return result, err
+	}
+
+	mutex.RLock()
+	p := provider
+	mutex.RUnlock()
+	if p == nil {
+		return result, kcoidc.ErrStatusNotInitialized
+	}
+
+	// Use the provider, which holds the configured role claim paths.
+	err = p.RequireRolesInClaims(result.ExtraClaims, []string{requiredRole})
+	if err != nil && debug {
+		fmt.Printf("kcoidc-c validate token and require role result in role require failure: %s\n", err)
+	}
+
+	return result, err
+}
+
+// ValidateDPoPToken validates the provided DPoP bound token string value
+// together with the provided DPoP proof for the provided HTTP method and URI
+// like ValidateToken. Error will be set when the validation of the token or
+// the proof failed.
+func ValidateDPoPToken(tokenString string, proofString string, method string, uri string) (*kcoidc.ValidationResult, error) {
+	mutex.RLock()
+	p := provider
+	ctx := initializedContext
+	mutex.RUnlock()
+
+	if debug {
+		fmt.Printf("kcoidc-c validate DPoP token: %s\n", tokenString)
+	}
+	if p == nil {
+		return &kcoidc.ValidationResult{}, kcoidc.ErrStatusNotInitialized
+	}
+
+	result, err := p.ValidateDPoPToken(ctx, tokenString, proofString, method, uri)
+	if err != nil && debug {
+		fmt.Printf("kcoidc-c validate DPoP token resulted in validation failure: %s\n", err)
+	}
+	return result, err
+}
+
+// ValidateCertificateBoundToken validates the provided certificate bound token
+// string value for the provided PEM encoded mutual-TLS client certificate like
+// ValidateToken. Error will be set when the validation failed or the token is
+// not bound to the certificate.
+func ValidateCertificateBoundToken(tokenString string, certPEM string) (*kcoidc.ValidationResult, error) {
+	mutex.RLock()
+	p := provider
+	ctx := initializedContext
+	mutex.RUnlock()
+
+	if debug {
+		fmt.Printf("kcoidc-c validate certificate bound token: %s\n", tokenString)
+	}
+	if p == nil {
+		return &kcoidc.ValidationResult{}, kcoidc.ErrStatusNotInitialized
+	}
+
+	block, _ := pem.Decode([]byte(certPEM))
+	if block == nil || block.Type != "CERTIFICATE" {
+		return &kcoidc.ValidationResult{}, kcoidc.ErrStatusInvalidCertificate
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		if debug {
+			fmt.Printf("kcoidc-c validate certificate bound token failed to parse certificate: %v\n", err)
+		}
+		return &kcoidc.ValidationResult{}, kcoidc.ErrStatusInvalidCertificate
+	}
+
+	result, err := p.ValidateCertificateBoundToken(ctx, tokenString, cert)
+	if err != nil && debug {
+		fmt.Printf("kcoidc-c validate certificate bound token resulted in validation failure: %s\n", err)
+	}
+	return result, err
+}
+
+// AuthenticatedUserIDClaimPath returns the claim path from which the
+// authenticated user ID is derived for the provided claims. If not found, an
+// empty string is returned.
+func AuthenticatedUserIDClaimPath(standardClaims *jwt.StandardClaims, extraClaims *kcoidc.ExtraClaimsWithType) string {
+	mutex.RLock()
+	p := provider
+	mutex.RUnlock()
+
+	if p == nil || extraClaims == nil {
+		return ""
+	}
+
+	_, claimPath, _ := p.AuthenticatedUserIDFromClaimPaths(standardClaims, extraClaims)
+	return claimPath
 }
 
 // FetchUserinfoWithAccesstokenString fetches the available user info for the
@@ -294,4 +978,86 @@ func FetchUserinfoWithAccesstokenString(tokenString string) (map[string]interfac
 	return userinfo, err
 }
 
+// KonnectIdentityFromClaimsJSON decodes the Kopano Konnect identity claims of
+// the provided JSON encoded extra claims as returned by the validate functions.
+func KonnectIdentityFromClaimsJSON(extraClaimsJSON string) (*kcoidc.KonnectIdentity, error) {
+	decoder := json.NewDecoder(strings.NewReader(extraClaimsJSON))
+	decoder.UseNumber()
+
+	extraClaims := &kcoidc.ExtraClaimsWithType{}
+	err := decoder.Decode(extraClaims)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", kcoidc.ErrStatusInvalidIdentityClaim, err)
+	}
+
+	identity, err := kcoidc.KonnectIdentityFromClaims(extraClaims)
+	if err != nil && debug {
+		fmt.Printf("kcoidc-c konnect identity from claims failure: %s\n", err)
+	}
+	return identity, err
+}
+
+// Status returns the current status of the initialized provider.
+func Status() (*kcoidc.Status, error) {
+	mutex.RLock()
+	p := provider
+	mutex.RUnlock()
+
+	if p == nil {
+		return nil, kcoidc.ErrStatusNotInitialized
+	}
+
+	return p.Status(), nil
+}
+
+// Refresh synchronously refreshes the discovery document and JWKS of the
+// initialized provider and returns what changed.
+func Refresh() (*kcoidc.RefreshResult, error) {
+	mutex.RLock()
+	p := provider
+	ctx := initializedContext
+	mutex.RUnlock()
+
+	if p == nil {
+		return nil, kcoidc.ErrStatusNotInitialized
+	}
+
+	result, err := p.Refresh(ctx)
+	if err != nil && debug {
+		fmt.Printf("kcoidc-c refresh failed: %v\n", err)
+	}
+	return result, err
+}
+
+// ClaimsProfile returns the claims profile used to derive values from token
+// claims.
+func ClaimsProfile() kcoidc.ClaimsProfile {
+	mutex.RLock()
+	profile := claimsProfile
+	mutex.RUnlock()
+
+	return profile
+}
+
+// IsGuest returns true if the provided extra claims are for a guest as defined
+// by the claims profile.
+func IsGuest(extraClaims *kcoidc.ExtraClaimsWithType) bool {
+	if extraClaims == nil {
+		return false
+	}
+
+	return ClaimsProfile().IsGuest(extraClaims)
+}
+
+func tokenTypesFromMask(mask int) []int {
+	var tokenTypes []int
+	for _, tokenType := range []int{kcoidc.TokenTypeStandard, kcoidc.TokenTypeKCAccess, kcoidc.TokenTypeKCRefresh, kcoidc.TokenTypeAccess} {
+		if mask&(1<<uint(tokenType)) != 0 {
+			tokenTypes = append(tokenTypes, tokenType)
+		}
+	}
+
+	return tokenTypes
+}
+
 func main() {}
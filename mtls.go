@@ -0,0 +1,56 @@
+/*
+ * SPDX-License-Identifier: AGPL-3.0-or-later
+ * Copyright 2020 Kopano and its licensors
+ */
+
+package kcoidc
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// CertificateThumbprint returns the base64url encoded SHA-256 thumbprint of the
+// DER encoding of the provided certificate as used in the x5t#S256
+// confirmation claim defined in RFC 8705.
+func CertificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// ValidateCertificateBoundTokenString validates the provided certificate bound
+// access token string value like ValidateTokenString and in addition ensures
+// that the token is bound to the provided mutual-TLS client certificate as
+// defined in RFC 8705. The cnf.x5t#S256 claim of the token must match the
+// thumbprint of the certificate, otherwise ErrStatusCertificateBindingMismatch
+// is returned.
+func (p *Provider) ValidateCertificateBoundTokenString(ctx context.Context, tokenString string, cert *x509.Certificate, opts ...ValidateOption) (string, *jwt.StandardClaims, *ExtraClaimsWithType, error) {
+	result, err := p.ValidateCertificateBoundToken(ctx, tokenString, cert, opts...)
+
+	return result.AuthenticatedUserID, result.StandardClaims, result.ExtraClaims, err
+}
+
+// ValidateCertificateBoundToken validates the provided certificate bound access
+// token string value like ValidateCertificateBoundTokenString and returns a
+// ValidationResult like ValidateToken.
+func (p *Provider) ValidateCertificateBoundToken(ctx context.Context, tokenString string, cert *x509.Certificate, opts ...ValidateOption) (*ValidationResult, error) {
+	result, err := p.ValidateToken(ctx, tokenString, opts...)
+	if err != nil {
+		return result, err
+	}
+
+	if cert == nil {
+		return result, ErrStatusCertificateBindingMismatch
+	}
+	x5t, _ := valueFromMapPath(*result.ExtraClaims, ConfirmationClaim+"."+X509CertificateThumbprintConfirmationClaim)
+	if x5t != CertificateThumbprint(cert) {
+		err = ErrStatusCertificateBindingMismatch
+	}
+
+	return result, err
+}
@@ -0,0 +1,41 @@
+/*
+ * SPDX-License-Identifier: AGPL-3.0-or-later
+ * Copyright 2020 Kopano and its licensors
+ */
+
+package kcoidc
+
+// A ValidateOption configures a single token validation, overriding the
+// settings of the accociated Provider for that call only.
+type ValidateOption func(*validateOptions)
+
+type validateOptions struct {
+	allowedTokenTypes []int
+	policy            *Policy
+}
+
+func newValidateOptions(opts []ValidateOption) *validateOptions {
+	options := &validateOptions{}
+	for _, opt := range opts {
+		opt(options)
+	}
+
+	return options
+}
+
+// WithAllowedTokenTypes returns a ValidateOption which restricts the accepted
+// token types to the provided ones. If no token type is provided, the token
+// type policy of the Provider is used.
+func WithAllowedTokenTypes(tokenTypes ...int) ValidateOption {
+	return func(options *validateOptions) {
+		options.allowedTokenTypes = tokenTypes
+	}
+}
+
+// WithPolicy returns a ValidateOption which validates the claims with the
+// provided Policy instead of the policy of the Provider.
+func WithPolicy(policy *Policy) ValidateOption {
+	return func(options *validateOptions) {
+		options.policy = policy
+	}
+}
@@ -0,0 +1,303 @@
+/*
+ * SPDX-License-Identifier: AGPL-3.0-or-later
+ * Copyright 2020 Kopano and its licensors
+ */
+
+package kcoidc
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// A Policy is a compiled declarative claims policy. Policies are created from
+// JSON documents, where each JSON object is a rule. All conditions of a rule
+// must be true for the rule to pass. The following conditions are supported:
+//
+//	"allOf": [rule, ...]      all of the rules must pass
+//	"anyOf": [rule, ...]      any of the rules must pass
+//	"not": rule               the rule must not pass
+//	"claim": "path"           the dot separated claim path the rule checks
+//	"exists": bool            the claim must exist or not
+//	"equals": value           the claim must equal the value
+//	"in": [value, ...]        the claim must equal any of the values
+//	"contains": value         the array claim must contain the value
+//	"matches": "regexp"       the string claim must match the regular expression
+//	"min": number             the numeric claim must be greater or equal
+//	"max": number             the numeric claim must be less or equal
+//	"guest": bool             the claims must be for a guest or not
+//
+// For example the following policy only allows non-guest users which are
+// member of the admins group and have an email address at example.com:
+//
+//	{
+//	  "guest": false,
+//	  "allOf": [
+//	    {"claim": "groups", "contains": "admins"},
+//	    {"claim": "email", "matches": "@example\\.com$"}
+//	  ]
+//	}
+type Policy struct {
+	rule policyRule
+}
+
+type policyDocument struct {
+	AllOf []*policyDocument `json:"allOf"`
+	AnyOf []*policyDocument `json:"anyOf"`
+	Not   *policyDocument   `json:"not"`
+
+	Claim    string        `json:"claim"`
+	Exists   *bool         `json:"exists"`
+	Equals   *interface{}  `json:"equals"`
+	In       []interface{} `json:"in"`
+	Contains *interface{}  `json:"contains"`
+	Matches  *string       `json:"matches"`
+	Min      *float64      `json:"min"`
+	Max      *float64      `json:"max"`
+	Guest    *bool         `json:"guest"`
+}
+
+type policyContext struct {
+	claims  map[string]interface{}
+	isGuest bool
+}
+
+type policyRule func(ctx *policyContext) bool
+
+// NewPolicyFromJSON compiles the provided JSON policy document into a Policy.
+func NewPolicyFromJSON(data []byte) (*Policy, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	decoder.UseNumber()
+
+	doc := &policyDocument{}
+	if err := decoder.Decode(doc); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrStatusInvalidPolicy, err)
+	}
+
+	rule, err := compilePolicyDocument(doc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrStatusInvalidPolicy, err)
+	}
+
+	return &Policy{
+		rule: rule,
+	}, nil
+}
+
+// LoadPolicyFile reads and compiles the JSON policy document found in the
+// file with the provided name.
+func LoadPolicyFile(fn string) (*Policy, error) {
+	data, err := ioutil.ReadFile(fn)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrStatusInvalidPolicy, err)
+	}
+
+	return NewPolicyFromJSON(data)
+}
+
+// Validate returns nil if the provided claims pass the accociated Policy.
+// Otherwise ErrStatusPolicyDenied is returned.
+func (policy *Policy) Validate(standardClaims *jwt.StandardClaims, claims *ExtraClaimsWithType, isGuest bool) error {
+	ctx := &policyContext{
+		claims:  make(map[string]interface{}),
+		isGuest: isGuest,
+	}
+	if claims != nil {
+		for k, v := range *claims {
+			ctx.claims[k] = v
+		}
+	}
+	if standardClaims != nil {
+		// Make split standard claims available to the policy as well.
+		setNonZeroPolicyClaim(ctx.claims, AudienceClaim, standardClaims.Audience)
+		setNonZeroPolicyClaim(ctx.claims, IDClaim, standardClaims.Id)
+		setNonZeroPolicyClaim(ctx.claims, IssuerClaim, standardClaims.Issuer)
+		setNonZeroPolicyClaim(ctx.claims, SubjectClaim, standardClaims.Subject)
+		setNonZeroPolicyClaim(ctx.claims, ExpiresAtClaim, standardClaims.ExpiresAt)
+		setNonZeroPolicyClaim(ctx.claims, IssuedAtClaim, standardClaims.IssuedAt)
+		setNonZeroPolicyClaim(ctx.claims, NotBeforeClaim, standardClaims.NotBefore)
+	}
+
+	if !policy.rule(ctx) {
+		return ErrStatusPolicyDenied
+	}
+
+	return nil
+}
+
+func setNonZeroPolicyClaim(claims map[string]interface{}, k string, v interface{}) {
+	switch vt := v.(type) {
+	case string:
+		if vt != "" {
+			claims[k] = vt
+		}
+	case int64:
+		if vt != 0 {
+			claims[k] = json.Number(strconv.FormatInt(vt, 10))
+		}
+	}
+}
+
+func compilePolicyDocument(doc *policyDocument) (policyRule, error) {
+	if doc == nil {
+		return nil, fmt.Errorf("empty rule")
+	}
+
+	var rules []policyRule
+
+	for _, sub := range doc.AllOf {
+		rule, err := compilePolicyDocument(sub)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	if doc.AnyOf != nil {
+		var anyRules []policyRule
+		for _, sub := range doc.AnyOf {
+			rule, err := compilePolicyDocument(sub)
+			if err != nil {
+				return nil, err
+			}
+			anyRules = append(anyRules, rule)
+		}
+		rules = append(rules, func(ctx *policyContext) bool {
+			for _, rule := range anyRules {
+				if rule(ctx) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+	if doc.Not != nil {
+		notRule, err := compilePolicyDocument(doc.Not)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, func(ctx *policyContext) bool {
+			return !notRule(ctx)
+		})
+	}
+	if doc.Guest != nil {
+		guest := *doc.Guest
+		rules = append(rules, func(ctx *policyContext) bool {
+			return ctx.isGuest == guest
+		})
+	}
+
+	claimRules, err := compilePolicyClaimConditions(doc)
+	if err != nil {
+		return nil, err
+	}
+	rules = append(rules, claimRules...)
+
+	if len(rules) == 0 {
+		return nil, fmt.Errorf("rule without conditions")
+	}
+
+	return func(ctx *policyContext) bool {
+		for _, rule := range rules {
+			if !rule(ctx) {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+func compilePolicyClaimConditions(doc *policyDocument) ([]policyRule, error) {
+	hasConditions := doc.Exists != nil || doc.Equals != nil || doc.In != nil || doc.Contains != nil || doc.Matches != nil || doc.Min != nil || doc.Max != nil
+	if doc.Claim == "" {
+		if hasConditions {
+			return nil, fmt.Errorf("claim conditions without claim")
+		}
+		return nil, nil
+	}
+	if !hasConditions {
+		return nil, fmt.Errorf("claim %s without conditions", doc.Claim)
+	}
+
+	var rules []policyRule
+	claimPath := doc.Claim
+
+	if doc.Exists != nil {
+		exists := *doc.Exists
+		rules = append(rules, func(ctx *policyContext) bool {
+			_, ok := valueFromMapPath(ctx.claims, claimPath)
+			return ok == exists
+		})
+	}
+	if doc.Equals != nil {
+		expected := *doc.Equals
+		rules = append(rules, func(ctx *policyContext) bool {
+			value, ok := valueFromMapPath(ctx.claims, claimPath)
+			return ok && claimValuesEqual(value, expected)
+		})
+	}
+	if doc.In != nil {
+		expected := doc.In
+		rules = append(rules, func(ctx *policyContext) bool {
+			value, ok := valueFromMapPath(ctx.claims, claimPath)
+			if !ok {
+				return false
+			}
+			for _, e := range expected {
+				if claimValuesEqual(value, e) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+	if doc.Contains != nil {
+		expected := *doc.Contains
+		rules = append(rules, func(ctx *policyContext) bool {
+			value, _ := valueFromMapPath(ctx.claims, claimPath)
+			values, _ := value.([]interface{})
+			for _, v := range values {
+				if claimValuesEqual(v, expected) {
+					return true
+				}
+			}
+			return false
+		})
+	}
+	if doc.Matches != nil {
+		re, err := regexp.Compile(*doc.Matches)
+		if err != nil {
+			return nil, fmt.Errorf("claim %s invalid regular expression: %v", claimPath, err)
+		}
+		rules = append(rules, func(ctx *policyContext) bool {
+			value, _ := valueFromMapPath(ctx.claims, claimPath)
+			s, ok := value.(string)
+			return ok && re.MatchString(s)
+		})
+	}
+	if doc.Min != nil || doc.Max != nil {
+		minValue, maxValue := doc.Min, doc.Max
+		rules = append(rules, func(ctx *policyContext) bool {
+			value, _ := valueFromMapPath(ctx.claims, claimPath)
+			n, ok := numberFromValue(value)
+			if !ok {
+				return false
+			}
+			if minValue != nil && n < *minValue {
+				return false
+			}
+			if maxValue != nil && n > *maxValue {
+				return false
+			}
+			return true
+		})
+	}
+
+	return rules, nil
+}
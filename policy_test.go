@@ -0,0 +1,74 @@
+/*
+ * SPDX-License-Identifier: AGPL-3.0-or-later
+ * Copyright 2020 Kopano and its licensors
+ */
+
+package kcoidc
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestPolicy(t *testing.T) {
+	policy, err := NewPolicyFromJSON([]byte(`{
+		"guest": false,
+		"allOf": [
+			{"claim": "iss", "equals": "https://issuer.example"},
+			{"claim": "groups", "contains": "admins"},
+			{"claim": "email", "matches": "@example\\.com$"},
+			{"claim": "kc.identity.level", "min": 1, "max": 5},
+			{"anyOf": [
+				{"claim": "kc.identity.kc.i.un", "in": ["user1", "user2"]},
+				{"not": {"claim": "kc.identity.kc.i.un", "exists": true}}
+			]}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("failed to compile policy: %v", err)
+	}
+
+	standardClaims := &jwt.StandardClaims{
+		Issuer: "https://issuer.example",
+	}
+	newClaims := func(un string, level float64) *ExtraClaimsWithType {
+		return &ExtraClaimsWithType{
+			"groups": []interface{}{"users", "admins"},
+			"email":  "user1@example.com",
+			IdentityClaim: map[string]interface{}{
+				"kc.i.un": un,
+				"level":   level,
+			},
+		}
+	}
+
+	if err := policy.Validate(standardClaims, newClaims("user1", 3), false); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := policy.Validate(standardClaims, newClaims("user1", 3), true); err != ErrStatusPolicyDenied {
+		t.Errorf("unexpected error for guest: %v", err)
+	}
+	if err := policy.Validate(standardClaims, newClaims("user3", 3), false); err != ErrStatusPolicyDenied {
+		t.Errorf("unexpected error for user3: %v", err)
+	}
+	if err := policy.Validate(standardClaims, newClaims("user2", 6), false); err != ErrStatusPolicyDenied {
+		t.Errorf("unexpected error for level 6: %v", err)
+	}
+}
+
+func TestPolicyInvalid(t *testing.T) {
+	for _, doc := range []string{
+		`{}`,
+		`{"claim": "sub"}`,
+		`{"equals": "user1"}`,
+		`{"claim": "sub", "matches": "("}`,
+		`{"claim": "sub", "unknown": true}`,
+		`{"anyOf": [{}]}`,
+	} {
+		if _, err := NewPolicyFromJSON([]byte(doc)); !errors.Is(err, ErrStatusInvalidPolicy) {
+			t.Errorf("unexpected error for %s: %v", doc, err)
+		}
+	}
+}
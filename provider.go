@@ -7,36 +7,93 @@ package kcoidc
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
 	"fmt"
 	"net/http"
 	"net/url"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
 	"github.com/openkop/oidc-go"
+	"gopkg.in/square/go-jose.v2"
 
 	"github.com/openkop/libkcoidc/internal/version"
 )
 
 // A Provider is a representation of an OpenID Connect Provider (OP).
 type Provider struct {
+	// Accessed atomically, keep first for 64-bit alignment.
+	retiredKeyValidations uint64
+
 	mutex sync.RWMutex
 
 	initialized bool
-	provider    *oidc.Provider
+	issuer      *url.URL
 	ready       chan struct{}
 
+	cancel  context.CancelFunc
+	stopped chan struct{}
+
+	initializeRetry      bool
+	initializeMinBackoff time.Duration
+	initializeMaxBackoff time.Duration
+	initializeError      error
+
+	refreshPolicy *RefreshPolicy
+
 	httpClient *http.Client
+	parser     *jwt.Parser
 
 	logger Logger
 	debug  bool
 
-	definition *oidc.ProviderDefinition
+	definition       *oidc.ProviderDefinition
+	lastUpdated      time.Time
+	lastRefreshError error
+	staleSince       time.Time
+	maxStaleness     time.Duration
+	stalenessPolicy  int
+
+	keys      *keySet
+	keyPolicy *KeyPolicy
+
+	extraKeys         []jose.JSONWebKey
+	pinnedThumbprints []string
+	keyGracePeriod    time.Duration
+	retiredKeys       []*retiredKey
+
+	decryptionKeys []jose.JSONWebKey
+
+	dpopProofMaxAge time.Duration
+	dpopReplay      *replayCache
+
+	maxTokenLength int
+	maxClaimsDepth int
+
+	allowedTokenTypes         []int
+	requireJWTAccessTokenType bool
+	claimsProfile             ClaimsProfile
+	userIDClaimPaths          []string
+	roleClaimPaths            []string
+	policy                    *Policy
+	guestPolicy               int
+	guestScopes               []string
+
+	idTokenSigningAlgs           []string
+	accessTokenSigningAlgs       []string
+	signingAlgsOverrideDiscovery bool
 }
 
 var emptyProviderDefintion = &oidc.ProviderDefinition{}
 
+// DefaultAllowedTokenTypes defines the token types which are accepted by a
+// Provider if not explicitly configured otherwise. Refresh tokens are not
+// included as they must never be used as bearer credentials.
+var DefaultAllowedTokenTypes = []int{TokenTypeStandard, TokenTypeKCAccess, TokenTypeAccess}
+
 // NewProvider creates a new Provider with the provider HTTP client. If no client
 // is provided, http.DefaultClient will be used.
 func NewProvider(client *http.Client, logger Logger, debug bool) (*Provider, error) {
@@ -50,9 +107,28 @@ func NewProvider(client *http.Client, logger Logger, debug bool) (*Provider, err
 
 	p := &Provider{
 		httpClient: client,
+		parser: &jwt.Parser{
+			// Decode numbers as json.Number to preserve their precision.
+			UseJSONNumber: true,
+		},
 
 		logger: logger,
 		debug:  debug,
+
+		keys: emptyKeySet,
+
+		dpopProofMaxAge: DefaultDPoPProofMaxAge,
+		dpopReplay:      newReplayCache(DefaultDPoPReplayCacheSize),
+
+		initializeRetry:      true,
+		initializeMinBackoff: DefaultRefreshMinBackoff,
+		initializeMaxBackoff: DefaultInitializeMaxBackoff,
+
+		maxTokenLength: DefaultMaxTokenLength,
+		maxClaimsDepth: DefaultMaxClaimsDepth,
+
+		allowedTokenTypes: DefaultAllowedTokenTypes,
+		claimsProfile:     DefaultClaimsProfile,
 	}
 	return p, nil
 }
@@ -67,88 +143,445 @@ func (p *Provider) BuildDate() string {
 	return version.BuildDate
 }
 
-// Initialize initializes the associated Provider with the provided issuer.
-func (p *Provider) Initialize(ctx context.Context, issuer *url.URL) error {
-	var err error
-	if issuer.Host == "" || issuer.Scheme != "https" {
-		return ErrStatusInvalidIss
+// SetAllowedTokenTypes sets the token types which are accepted by the
+// associated Provider when validating tokens. If no token types are provided,
+// DefaultAllowedTokenTypes is used.
+func (p *Provider) SetAllowedTokenTypes(tokenTypes ...int) error {
+	if len(tokenTypes) == 0 {
+		tokenTypes = DefaultAllowedTokenTypes
 	}
 
 	p.mutex.Lock()
-	defer p.mutex.Unlock()
-	if p.initialized {
-		return ErrStatusAlreadyInitialized
+	p.allowedTokenTypes = tokenTypes
+	p.mutex.Unlock()
+
+	return nil
+}
+
+// SetRequireJWTAccessTokenType sets if the associated Provider only accepts
+// tokens with the typ header of JWT access tokens as defined in RFC 9068.
+func (p *Provider) SetRequireJWTAccessTokenType(required bool) error {
+	p.mutex.Lock()
+	p.requireJWTAccessTokenType = required
+	p.mutex.Unlock()
+
+	return nil
+}
+
+// SetClaimsProfile sets the claims profile which is used by the associated
+// Provider to derive values from token claims. If nil is provided, the
+// DefaultClaimsProfile is used.
+func (p *Provider) SetClaimsProfile(profile ClaimsProfile) error {
+	if profile == nil {
+		profile = DefaultClaimsProfile
+	}
+
+	p.mutex.Lock()
+	p.claimsProfile = profile
+	p.mutex.Unlock()
+
+	return nil
+}
+
+// SetUserIDClaimPaths sets the ordered claim paths which are used by the
+// associated Provider to derive the authenticated user ID. Claim paths are dot
+// separated to reach into nested claims, for example "kc.identity.kc.i.id". The
+// first claim path found in the claims is used. If none is found, validation
+// fails with ErrStatusMissingUserIDClaim. If no claim paths are provided, the
+// claim paths of the claims profile are used.
+func (p *Provider) SetUserIDClaimPaths(claimPaths ...string) error {
+	p.mutex.Lock()
+	p.userIDClaimPaths = claimPaths
+	p.mutex.Unlock()
+
+	return nil
+}
+
+// SetRoleClaimPaths sets the claim paths which are used by the associated
+// Provider to look up roles and groups. Claim paths are dot separated to reach
+// into nested claims. If no claim paths are provided, DefaultRoleClaimPaths are
+// used.
+func (p *Provider) SetRoleClaimPaths(claimPaths ...string) error {
+	p.mutex.Lock()
+	p.roleClaimPaths = claimPaths
+	p.mutex.Unlock()
+
+	return nil
+}
+
+// SetPolicy sets the claims policy which is evaluated by the associated
+// Provider when validating tokens. If nil is provided, no policy is used.
+func (p *Provider) SetPolicy(policy *Policy) error {
+	p.mutex.Lock()
+	p.policy = policy
+	p.mutex.Unlock()
+
+	return nil
+}
+
+// SetGuestPolicy sets how the associated Provider treats tokens of guest
+// identities as defined by its claims profile. With GuestPolicyRestrictScopes,
+// guest tokens are accepted but only those of their authorized scopes which are
+// included in the provided scopes are kept in the extra claims, the original
+// claims of the ValidationResult are unchanged. Denied guests fail validation
+// with ErrStatusGuestDenied.
+func (p *Provider) SetGuestPolicy(guestPolicy int, scopes ...string) error {
+	switch guestPolicy {
+	case GuestPolicyAllow, GuestPolicyDeny, GuestPolicyRestrictScopes:
+	default:
+		return ErrStatusInvalidGuestPolicy
 	}
 
-	ready := make(chan struct{})
-	p.ready = ready
+	p.mutex.Lock()
+	p.guestPolicy = guestPolicy
+	p.guestScopes = scopes
+	p.mutex.Unlock()
+
+	return nil
+}
 
-	updates := make(chan *oidc.ProviderDefinition)
-	config := &oidc.ProviderConfig{
-		HTTPClient: p.httpClient,
-		Logger:     p.logger,
+// SetStalenessPolicy sets for how long the associated Provider keeps using
+// its last known good discovery document and JWKS when refreshing them fails,
+// and how it behaves once that max staleness is exceeded. With
+// StalenessPolicyDeny, token validation fails with ErrStatusProviderStale,
+// with StalenessPolicyAllow, the staleness is only reported. If zero max
+// staleness is provided, there is no limit.
+func (p *Provider) SetStalenessPolicy(maxStaleness time.Duration, stalenessPolicy int) error {
+	switch stalenessPolicy {
+	case StalenessPolicyAllow, StalenessPolicyDeny:
+	default:
+		return ErrStatusInvalidStalenessPolicy
 	}
-	provider, err := oidc.NewProvider(issuer, config)
-	if err != nil {
-		if p.logger != nil {
-			p.logger.Printf("kcoidc initialize failed to created provider: %v", err)
+	if maxStaleness < 0 {
+		return ErrStatusInvalidStalenessPolicy
+	}
+
+	p.mutex.Lock()
+	p.maxStaleness = maxStaleness
+	p.stalenessPolicy = stalenessPolicy
+	p.mutex.Unlock()
+
+	return nil
+}
+
+// SetSigningAlgs sets the signing algorithms which are accepted by the
+// associated Provider for ID tokens and for access tokens. If override is
+// false, the provided algorithms are intersected with the ID token signing
+// algorithms of the discovery document, otherwise the algorithms of the
+// discovery document are ignored. Without algorithms, the discovery document is
+// used for ID tokens and the ID token algorithms are used for access tokens.
+// The "none" and HMAC algorithms are always rejected, as tokens are verified
+// with public keys. Configuring them fails with ErrStatusInvalidSigningAlg.
+func (p *Provider) SetSigningAlgs(idTokenAlgs, accessTokenAlgs []string, override bool) error {
+	for _, algs := range [][]string{idTokenAlgs, accessTokenAlgs} {
+		for _, alg := range algs {
+			if isSigningAlgForbidden(alg) {
+				return ErrStatusInvalidSigningAlg
+			}
+		}
+	}
+
+	p.mutex.Lock()
+	p.idTokenSigningAlgs = idTokenAlgs
+	p.accessTokenSigningAlgs = accessTokenAlgs
+	p.signingAlgsOverrideDiscovery = override
+	p.mutex.Unlock()
+
+	return nil
+}
+
+// SetKeyPolicy sets the key policy of the associated Provider. Keys of the
+// JWKS which do not conform to the key policy are excluded from key selection
+// and are reported in the Status. If nil is provided, all supported keys are
+// used.
+func (p *Provider) SetKeyPolicy(policy *KeyPolicy) error {
+	p.mutex.Lock()
+	p.keyPolicy = policy
+	p.updateKeys()
+	p.mutex.Unlock()
+
+	return nil
+}
+
+// SetExtraKeys sets additional trusted public keys which are used by the
+// associated Provider for token signature verification in addition to the
+// keys of the JWKS of the OP. Extra keys are subject to the key policy like
+// all other keys and are matched by the kid header of tokens with their key
+// ID. Extra keys without key ID only match tokens without kid header.
+func (p *Provider) SetExtraKeys(keys ...jose.JSONWebKey) error {
+	for _, key := range keys {
+		if !key.IsPublic() {
+			return fmt.Errorf("%w: key %s is not a public key", ErrStatusInvalidKey, key.KeyID)
+		}
+	}
+
+	p.mutex.Lock()
+	p.extraKeys = keys
+	p.updateKeys()
+	p.mutex.Unlock()
+
+	return nil
+}
+
+// SetPinnedKeys restricts token signature verification of the associated
+// Provider to the keys with the provided base64url encoded SHA-256 JWK
+// thumbprints as defined in RFC 7638. Pinning applies to the keys of the JWKS
+// and to extra keys. If no thumbprints are provided, pinning is disabled.
+func (p *Provider) SetPinnedKeys(thumbprints ...string) error {
+	for _, thumbprint := range thumbprints {
+		if b, err := base64.RawURLEncoding.DecodeString(thumbprint); err != nil || len(b) != sha256.Size {
+			return fmt.Errorf("%w: invalid thumbprint %s", ErrStatusInvalidKey, thumbprint)
 		}
+	}
+
+	p.mutex.Lock()
+	p.pinnedThumbprints = thumbprints
+	p.updateKeys()
+	p.mutex.Unlock()
+
+	return nil
+}
+
+// SetKeyGracePeriod sets the duration for which keys which were removed from
+// the JWKS of the OP are still accepted by the associated Provider. Tokens
+// validated with such a retired key must have been issued before the key was
+// removed. If a max token lifetime is provided, the grace period is bounded by
+// it since no token signed with a retired key can be valid for longer. If
+// zero is provided, removed keys are no longer accepted immediately. Negative
+// values are rejected with ErrStatusInvalidKeyGracePeriod.
+func (p *Provider) SetKeyGracePeriod(gracePeriod time.Duration, maxTokenLifetime time.Duration) error {
+	if gracePeriod < 0 || maxTokenLifetime < 0 {
+		return ErrStatusInvalidKeyGracePeriod
+	}
+	if maxTokenLifetime > 0 && gracePeriod > maxTokenLifetime {
+		gracePeriod = maxTokenLifetime
+	}
+
+	p.mutex.Lock()
+	p.keyGracePeriod = gracePeriod
+	if gracePeriod == 0 && len(p.retiredKeys) > 0 {
+		p.retiredKeys = nil
+		p.updateKeys()
+	}
+	p.mutex.Unlock()
+
+	return nil
+}
+
+// SetDecryptionKeys sets the private keys which are used by the associated
+// Provider to decrypt encrypted tokens (JWE). The decrypted inner token is then
+// validated as usual. If no keys are provided, encrypted tokens are rejected.
+func (p *Provider) SetDecryptionKeys(keys ...jose.JSONWebKey) error {
+	for _, key := range keys {
+		if key.IsPublic() {
+			return ErrStatusInvalidDecryptionKey
+		}
+	}
+
+	p.mutex.Lock()
+	p.decryptionKeys = keys
+	p.mutex.Unlock()
+
+	return nil
+}
+
+// SetDPoPProofMaxAge sets the maximum age of DPoP proofs which is accepted by
+// the associated Provider. The same duration is accepted as clock skew for
+// proofs issued in the future. Seen proof IDs are remembered for twice that
+// duration to detect replays. If zero is provided, DefaultDPoPProofMaxAge is
+// used.
+func (p *Provider) SetDPoPProofMaxAge(maxAge time.Duration) error {
+	if maxAge == 0 {
+		maxAge = DefaultDPoPProofMaxAge
+	}
+
+	p.mutex.Lock()
+	p.dpopProofMaxAge = maxAge
+	p.mutex.Unlock()
+
+	return nil
+}
+
+// SetTokenLimits sets the maximum length of token strings and the maximum
+// nesting depth of token claims which are accepted by the associated Provider.
+// Longer tokens are rejected with ErrStatusTokenTooLarge before they are
+// decoded, tokens with deeper claims are rejected with
+// ErrStatusTokenClaimsTooDeep. If zero is provided, DefaultMaxTokenLength and
+// DefaultMaxClaimsDepth are used respectively. Negative limits fail with
+// ErrStatusInvalidTokenLimits.
+func (p *Provider) SetTokenLimits(maxLength int, maxClaimsDepth int) error {
+	if maxLength == 0 {
+		maxLength = DefaultMaxTokenLength
+	}
+	if maxClaimsDepth == 0 {
+		maxClaimsDepth = DefaultMaxClaimsDepth
+	}
+	if maxLength < 0 || maxClaimsDepth < 0 {
+		return ErrStatusInvalidTokenLimits
+	}
+
+	p.mutex.Lock()
+	p.maxTokenLength = maxLength
+	p.maxClaimsDepth = maxClaimsDepth
+	p.mutex.Unlock()
+
+	return nil
+}
+
+// ClaimsProfile returns the claims profile of the associated Provider.
+func (p *Provider) ClaimsProfile() ClaimsProfile {
+	p.mutex.RLock()
+	profile := p.claimsProfile
+	p.mutex.RUnlock()
+
+	return profile
+}
+
+// Initialize initializes the associated Provider with the provided issuer and
+// fetches its discovery document and JWKS in the background. Failed fetches
+// are retried, WaitUntilReady and Status report their errors which wrap
+// ErrStatusDNSFailure, ErrStatusTLSFailure, ErrStatusHTTPFailure,
+// ErrStatusInvalidIss or ErrStatusInvalidProviderResponse. If retrying is
+// disabled with SetInitializeRetry, the first fetch is done before Initialize
+// returns and such failures are returned directly instead.
+func (p *Provider) Initialize(ctx context.Context, issuer *url.URL) error {
+	if issuer.Host == "" || issuer.Scheme != "https" {
 		return ErrStatusInvalidIss
 	}
-	err = provider.Initialize(ctx, updates, nil)
-	if err != nil {
-		if p.logger != nil {
-			p.logger.Printf("kcoidc initialize failed with error: %v", err)
+
+	p.mutex.RLock()
+	initialized := p.initialized
+	retry := p.initializeRetry
+	minBackoff, maxBackoff := p.initializeMinBackoff, p.initializeMaxBackoff
+	p.mutex.RUnlock()
+	if initialized {
+		return ErrStatusAlreadyInitialized
+	}
+
+	var definition *oidc.ProviderDefinition
+	var expires time.Time
+	if !retry {
+		// Fail fast, fetch the definition once to report failures directly.
+		var err error
+		definition, expires, err = p.fetchDefinition(ctx, issuer)
+		if err != nil {
+			if p.logger != nil {
+				p.logger.Printf("kcoidc initialize failed with error: %v", err)
+			}
+			return err
 		}
-		return ErrStatusUnknown
 	}
 
-	p.provider = provider
-	p.definition = emptyProviderDefintion
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.initialized {
+		return ErrStatusAlreadyInitialized
+	}
+
+	p.ready = make(chan struct{})
+	p.issuer = issuer
+	p.initializeError = nil
+	p.setDefinition(emptyProviderDefintion)
 	p.initialized = true
+	if definition != nil {
+		p.applyDefinition(definition, time.Now())
+	}
+
+	var backgroundCtx context.Context
+	backgroundCtx, p.cancel = context.WithCancel(ctx)
+	stopped := make(chan struct{})
+	p.stopped = stopped
+	policy := p.refreshPolicy
 
 	go func() {
-		for {
-			select {
-			case <-ctx.Done():
+		defer close(stopped)
+		if definition == nil {
+			var ok bool
+			if expires, ok = p.initializeDefinition(backgroundCtx, minBackoff, maxBackoff); !ok {
 				return
-			case update := <-updates:
-				if update == nil {
-					return
-				}
-				p.mutex.Lock()
-				d := p.definition
-				p.definition = update
-				p.mutex.Unlock()
-				if d == emptyProviderDefintion {
-					close(ready)
-				}
 			}
 		}
+		p.refreshLoop(backgroundCtx, policy, expires)
 	}()
+
 	return nil
 }
 
+// applyDefinition replaces the definition of the associated Provider with the
+// provided refreshed definition, records the successful refresh and signals
+// readiness with the first definition. The caller must hold the write lock.
+func (p *Provider) applyDefinition(definition *oidc.ProviderDefinition, now time.Time) {
+	previous := p.definition
+	p.setDefinition(definition)
+	p.refreshSucceeded(now)
+	if previous == emptyProviderDefintion {
+		close(p.ready)
+	}
+}
+
+// setDefinition replaces the definition of the associated Provider and
+// rebuilds its keys. The caller must hold the write lock.
+func (p *Provider) setDefinition(definition *oidc.ProviderDefinition) {
+	if definition == emptyProviderDefintion {
+		// Initialize resets the definition, keys of a previous initialization
+		// are not retired but dropped.
+		p.retiredKeys = nil
+	} else if p.definition != nil && p.keyGracePeriod > 0 {
+		// Keep keys which were removed from the JWKS for the grace period.
+		now := time.Now()
+		p.retiredKeys = retireKeys(p.retiredKeys, p.definition.JWKS, definition.JWKS, now, now.Add(p.keyGracePeriod))
+	}
+	p.definition = definition
+	p.updateKeys()
+}
+
+// updateKeys rebuilds the keys of the associated Provider from its current
+// definition. The caller must hold the write lock.
+func (p *Provider) updateKeys() {
+	var jwks *jose.JSONWebKeySet
+	if p.definition != nil {
+		jwks = p.definition.JWKS
+	}
+
+	p.keys = newKeySet(jwks, p.extraKeys, p.retiredKeys, p.keyPolicy, p.pinnedThumbprints)
+	if p.logger != nil {
+		for _, status := range p.keys.status {
+			if !status.Accepted {
+				p.logger.Printf("kcoidc key %s excluded: %s\n", status.KeyID, status.Reason)
+			}
+		}
+	}
+}
+
 // Uninitialize uninitializes the associated Provider.
 func (p *Provider) Uninitialize() error {
 	p.mutex.Lock()
-	defer p.mutex.Unlock()
-
 	if !p.initialized {
+		p.mutex.Unlock()
 		return ErrStatusNotInitialized
 	}
-
-	err := p.provider.Shutdown()
-	if p.logger != nil {
-		p.logger.Printf("kcoidc provider shutdown with error: %v", err)
-	}
+	cancel := p.cancel
+	stopped := p.stopped
 	p.initialized = false
-	p.provider = nil
+	p.cancel = nil
+	p.stopped = nil
+	p.mutex.Unlock()
+
+	// Cancel to abort pending fetches, then wait without holding the lock so
+	// the background refreshes can finish.
+	if cancel != nil {
+		cancel()
+	}
+	if stopped != nil {
+		<-stopped
+	}
 
-	return err
+	return nil
 }
 
 // WaitUntilReady blocks until the associated Provider is ready or timeout.
+// On timeout, the error of the last failed initialization attempt is returned
+// if there is one, otherwise ErrStatusTimeout.
 func (p *Provider) WaitUntilReady(ctx context.Context, timeout time.Duration) error {
 	p.mutex.RLock()
 	if !p.initialized {
@@ -164,54 +597,172 @@ func (p *Provider) WaitUntilReady(ctx context.Context, timeout time.Duration) er
 	case <-ctx.Done():
 	case <-time.After(timeout):
 		err = ErrStatusTimeout
+		p.mutex.RLock()
+		if p.initializeError != nil {
+			// Report why initialization is not ready yet.
+			err = p.initializeError
+		}
+		p.mutex.RUnlock()
 	}
 
 	return err
 }
 
+// A ValidationResult holds the results of a token validation.
+type ValidationResult struct {
+	AuthenticatedUserID string
+
+	// Header is the decoded JOSE header of the token.
+	Header map[string]interface{}
+	// Claims are all the original claims of the token.
+	Claims map[string]interface{}
+
+	// TokenType is the numeric token type as defined by the claims profile
+	// and the typ header of the token.
+	TokenType int
+
+	// Encrypted is true if the token was encrypted (JWE). Header is the header
+	// of the signed inner token in that case.
+	Encrypted bool
+
+	// StandardClaims and ExtraClaims are the split views of Claims.
+	StandardClaims *jwt.StandardClaims
+	ExtraClaims    *ExtraClaimsWithType
+}
+
+// reportedKeyFuncErrStatuses are the errors of the key func which are returned
+// as is when validating tokens. For compatibility, all other key func errors
+// like unexpected signing methods result in ErrStatusTokenInvalidSignature.
+var reportedKeyFuncErrStatuses = map[ErrStatus]bool{
+	ErrStatusTokenUnknownKey:          true,
+	ErrStatusTokenKeyRejected:         true,
+	ErrStatusTokenUnsupportedCritical: true,
+	ErrStatusTokenEmbeddedKey:         true,
+}
+
 // ValidateTokenString validates the provided token string value with the keys
 // of the accociated Provider and returns the authenticated users ID as found in
-// the claims, the standard claims and all extra claims.
-func (p *Provider) ValidateTokenString(ctx context.Context, tokenString string) (string, *jwt.StandardClaims, *ExtraClaimsWithType, error) {
+// the claims, the standard claims and all extra claims. The provided options
+// can be used to override settings of the accociated Provider for this call.
+func (p *Provider) ValidateTokenString(ctx context.Context, tokenString string, opts ...ValidateOption) (string, *jwt.StandardClaims, *ExtraClaimsWithType, error) {
+	result, err := p.ValidateToken(ctx, tokenString, opts...)
+
+	return result.AuthenticatedUserID, result.StandardClaims, result.ExtraClaims, err
+}
+
+// ValidateToken validates the provided token string value like
+// ValidateTokenString and returns a ValidationResult, which in addition holds
+// the decoded JOSE header and the full original claims of the token. The
+// returned ValidationResult is never nil, even if validation failed.
+func (p *Provider) ValidateToken(ctx context.Context, tokenString string, opts ...ValidateOption) (*ValidationResult, error) {
+	options := newValidateOptions(opts)
+	result := &ValidationResult{}
+
 	p.mutex.RLock()
 	ddoc := p.definition.WellKnown
 	jwks := p.definition.JWKS
+	keys := p.keys
+	allowedTokenTypes := p.allowedTokenTypes
+	requireJWTAccessTokenType := p.requireJWTAccessTokenType
+	profile := p.claimsProfile
+	userIDClaimPaths := p.userIDClaimPaths
+	policy := p.policy
+	guestPolicy := p.guestPolicy
+	guestScopes := p.guestScopes
+	idTokenSigningAlgs := p.idTokenSigningAlgs
+	accessTokenSigningAlgs := p.accessTokenSigningAlgs
+	signingAlgsOverrideDiscovery := p.signingAlgsOverrideDiscovery
+	decryptionKeys := p.decryptionKeys
+	maxTokenLength := p.maxTokenLength
+	maxClaimsDepth := p.maxClaimsDepth
+	stale := p.stalenessPolicy == StalenessPolicyDeny && p.maxStaleness > 0 && p.staleness(time.Now()) > p.maxStaleness
 	p.mutex.RUnlock()
 	if ddoc == nil || jwks == nil {
-		return "", nil, nil, ErrStatusNotInitialized
+		return result, ErrStatusNotInitialized
+	}
+	if stale {
+		return result, ErrStatusProviderStale
+	}
+	if len(tokenString) > maxTokenLength {
+		return result, ErrStatusTokenTooLarge
+	}
+	if len(accessTokenSigningAlgs) == 0 {
+		accessTokenSigningAlgs = idTokenSigningAlgs
+	}
+	idTokenSigningAlgs = effectiveSigningAlgs(idTokenSigningAlgs, ddoc.IDTokenSigningAlgValuesSupported, signingAlgsOverrideDiscovery)
+	accessTokenSigningAlgs = effectiveSigningAlgs(accessTokenSigningAlgs, ddoc.IDTokenSigningAlgValuesSupported, signingAlgsOverrideDiscovery)
+	if len(options.allowedTokenTypes) > 0 {
+		allowedTokenTypes = options.allowedTokenTypes
+	}
+	if options.policy != nil {
+		policy = options.policy
 	}
 
+	if isEncryptedToken(tokenString) {
+		// Nested token, decrypt to get the signed inner token.
+		innerTokenString, decryptErr := decryptToken(tokenString, decryptionKeys)
+		if decryptErr != nil {
+			return result, decryptErr
+		}
+		if len(innerTokenString) > maxTokenLength {
+			return result, ErrStatusTokenTooLarge
+		}
+		tokenString = innerTokenString
+		result.Encrypted = true
+	}
+
+	var retired *retiredKey
 	claims := &ExtraClaimsWithType{}
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+	token, err := p.parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
 		if p.debug && p.logger != nil {
 			p.logger.Printf("kcoidc validate token header: %#v\n", token.Header)
 		}
 
-		supportedAlg := false
-		for _, alg := range ddoc.IDTokenSigningAlgValuesSupported {
-			if token.Method.Alg() == alg {
-				supportedAlg = true
-				break
-			}
+		if headerErr := validateHeader(token.Header); headerErr != nil {
+			return nil, headerErr
 		}
-		if !supportedAlg {
+		if isSigningMethodForbidden(token.Method) {
+			return nil, ErrStatusTokenUnexpectedSigningMethod
+		}
+		// Claims are already decoded here, use them to select the algorithms.
+		signingAlgs := idTokenSigningAlgs
+		if tokenTypeWithHeader(profile, token.Header, claims) != TokenTypeStandard {
+			signingAlgs = accessTokenSigningAlgs
+		}
+		if !isStringInSlice(signingAlgs, token.Method.Alg()) {
 			return nil, ErrStatusTokenUnexpectedSigningMethod
 		}
 
 		kid, _ := (token.Header["kid"].(string))
-		keys := jwks.Key(kid)
-		if keys == nil || len(keys) == 0 {
+		candidates, found := keys.lookup(kid, token.Method.Alg(), time.Now())
+		if len(candidates) == 0 {
+			if found {
+				return nil, ErrStatusTokenKeyRejected
+			}
 			return nil, ErrStatusTokenUnknownKey
 		}
 
-		key := keys[0]
+		// Multiple keys can share the key ID, for example when the OP
+		// rotated a key without changing it. Use the first which verifies.
+		entry := selectKeyEntry(token, candidates)
+		key := entry.key
+		retired = entry.retired
 		if p.debug && p.logger != nil {
-			p.logger.Printf("kcoidc validate token key: %#v (%v)\n", key.Key, kid)
+			p.logger.Printf("kcoidc validate token key: %#v (%v, retired: %v)\n", key.Key, kid, retired != nil)
 		}
 
 		return key.Key, nil
 	})
 
+	if token != nil {
+		result.Header = token.Header
+	}
+	// Keep the original claims before the standard claims get split.
+	result.Claims = make(map[string]interface{}, len(*claims))
+	for k, v := range *claims {
+		result.Claims[k] = copyValue(v)
+	}
+
 	// Get standard claims.
 	standardClaims, standardClaimsErr := SplitStandardClaimsFromMapClaims(claims)
 	if err == nil {
@@ -220,13 +771,35 @@ func (p *Provider) ValidateTokenString(ctx context.Context, tokenString string)
 	if err == nil {
 		err = standardClaims.Valid()
 	}
+	if err == nil && claimsDepth(result.Claims, maxClaimsDepth) > maxClaimsDepth {
+		err = ErrStatusTokenClaimsTooDeep
+	}
 	if err == nil && !token.Valid {
 		// NOTE(longsleep): Can this actually happen?
 		err = ErrStatusTokenValidationFailed
 	}
+	if err == nil && retired != nil {
+		// Retired keys are only accepted for tokens issued before retirement.
+		if standardClaims.IssuedAt == 0 || standardClaims.IssuedAt > retired.retiredAt.Unix() {
+			err = ErrStatusTokenKeyRejected
+		} else {
+			atomic.AddUint64(&p.retiredKeyValidations, 1)
+		}
+	}
+	if err == nil {
+		err = validateJWTAccessToken(token.Header, result.Claims[AudienceClaim], standardClaims, claims, requireJWTAccessTokenType)
+	}
+	if token != nil {
+		result.TokenType = tokenTypeWithHeader(profile, token.Header, claims)
+	}
+	if err == nil && !isTokenTypeAllowed(result.TokenType, allowedTokenTypes) {
+		err = ErrStatusTokenTypeNotAllowed
+	}
 	if err != nil {
 		if ve, ok := err.(*jwt.ValidationError); ok {
-			if ve.Errors&jwt.ValidationErrorMalformed != 0 {
+			if errStatus, ok := ve.Inner.(ErrStatus); ok && reportedKeyFuncErrStatuses[errStatus] {
+				err = errStatus
+			} else if ve.Errors&jwt.ValidationErrorMalformed != 0 {
 				err = ErrStatusTokenMalformed
 			} else if ve.Errors&(jwt.ValidationErrorSignatureInvalid|jwt.ValidationErrorUnverifiable) != 0 {
 				err = ErrStatusTokenInvalidSignature
@@ -239,19 +812,98 @@ func (p *Provider) ValidateTokenString(ctx context.Context, tokenString string)
 	}
 
 	// Get authenticated UserID
-	authenticatedUserID, ok := AuthenticatedUserIDFromClaims(claims)
+	if len(userIDClaimPaths) == 0 {
+		userIDClaimPaths = profile.UserIDClaimPaths()
+	}
+	authenticatedUserID, _, ok := AuthenticatedUserIDFromClaimPaths(userIDClaimPaths, standardClaims, claims)
+	if err == nil && !ok {
+		err = ErrStatusMissingUserIDClaim
+	}
+	isGuest := profile.IsGuest(claims)
+	if err == nil {
+		err = validateGuestPolicy(guestPolicy, guestScopes, claims, isGuest)
+	}
+	if err == nil && policy != nil {
+		err = policy.Validate(standardClaims, claims, isGuest)
+	}
+
+	result.AuthenticatedUserID = authenticatedUserID
+	result.StandardClaims = standardClaims
+	result.ExtraClaims = claims
+
+	return result, err
+}
+
+// AuthenticatedUserIDFromClaimPaths returns the authenticated user ID from the
+// provided claims as defined by the user ID claim paths of the associated
+// Provider, together with the claim path where it was found.
+func (p *Provider) AuthenticatedUserIDFromClaimPaths(standardClaims *jwt.StandardClaims, claims *ExtraClaimsWithType) (string, string, error) {
+	p.mutex.RLock()
+	userIDClaimPaths := p.userIDClaimPaths
+	if len(userIDClaimPaths) == 0 {
+		userIDClaimPaths = p.claimsProfile.UserIDClaimPaths()
+	}
+	p.mutex.RUnlock()
+
+	authenticatedUserID, claimPath, ok := AuthenticatedUserIDFromClaimPaths(userIDClaimPaths, standardClaims, claims)
 	if !ok {
-		// NOTE(longsleep): Fallback to standard Subject if no extra information
-		// is set in token. This can happen for older Konnect installations
-		// which did not set this claim. Let's do this for compatibility.
-		authenticatedUserID = standardClaims.Subject
+		return "", "", ErrStatusMissingUserIDClaim
 	}
 
-	return authenticatedUserID, standardClaims, claims, err
+	return authenticatedUserID, claimPath, nil
+}
+
+// TokenType returns the numeric token type of the provided extra claims as
+// defined by the claims profile of the associated Provider.
+func (p *Provider) TokenType(claims *ExtraClaimsWithType) int {
+	return p.ClaimsProfile().TokenType(claims)
+}
+
+// IsGuest returns true if the provided extra claims are for a guest as defined
+// by the claims profile of the associated Provider.
+func (p *Provider) IsGuest(claims *ExtraClaimsWithType) bool {
+	return p.ClaimsProfile().IsGuest(claims)
+}
+
+// RequireScopesInClaims returns nil if all the provided scopes are found in
+// the provided claims as defined by the claims profile of the associated
+// Provider. Otherwise a *MissingScopesError is returned, which matches
+// ErrStatusMissingRequiredScope with errors.Is.
+func (p *Provider) RequireScopesInClaims(claims *ExtraClaimsWithType, requiredScopes []string) error {
+	return RequireScopesInClaimsWithProfile(p.ClaimsProfile(), claims, requiredScopes)
+}
+
+// RolesFromClaims returns the roles and groups as bool map found in the
+// provided claims at the role claim paths of the associated Provider.
+func (p *Provider) RolesFromClaims(claims *ExtraClaimsWithType) map[string]bool {
+	return claims.Roles(p.getRoleClaimPaths()...)
+}
+
+// RequireRolesInClaims returns nil if all the provided roles are found in the
+// provided claims at the role claim paths of the associated Provider.
+// Otherwise an error is returned.
+func (p *Provider) RequireRolesInClaims(claims *ExtraClaimsWithType, requiredRoles []string) error {
+	return RequireRolesInClaims(claims, requiredRoles, p.getRoleClaimPaths()...)
+}
+
+// RequireAnyRoleInClaims returns nil if any of the provided roles is found in
+// the provided claims at the role claim paths of the associated Provider.
+// Otherwise an error is returned.
+func (p *Provider) RequireAnyRoleInClaims(claims *ExtraClaimsWithType, anyRoles []string) error {
+	return RequireAnyRoleInClaims(claims, anyRoles, p.getRoleClaimPaths()...)
+}
+
+func (p *Provider) getRoleClaimPaths() []string {
+	p.mutex.RLock()
+	roleClaimPaths := p.roleClaimPaths
+	p.mutex.RUnlock()
+
+	return roleClaimPaths
 }
 
 // FetchUserinfoWithAccesstokenString fetches the the userinfo result of the
-// accociated provider for the provided access token string.
+// accociated provider for the provided access token string. Unlike token
+// claims, numbers in the userinfo result are decoded as float64.
 func (p *Provider) FetchUserinfoWithAccesstokenString(ctx context.Context, tokenString string) (map[string]interface{}, error) {
 	p.mutex.RLock()
 	ddoc := p.definition.WellKnown
@@ -267,5 +919,6 @@ func (p *Provider) FetchUserinfoWithAccesstokenString(ctx context.Context, token
 		"Authorization": []string{fmt.Sprintf("Bearer %s", tokenString)},
 	}
 
-	return userinfo, fetchJSON(ctx, p.httpClient, ddoc.UserInfoEndpoint, headers, contentTypeJSONOnly, &userinfo)
+	_, err := fetchJSON(ctx, p.httpClient, ddoc.UserInfoEndpoint, headers, contentTypeJSONOnly, false, &userinfo)
+	return userinfo, err
 }
@@ -0,0 +1,1234 @@
+/*
+ * SPDX-License-Identifier: AGPL-3.0-or-later
+ * Copyright 2020 Kopano and its licensors
+ */
+
+package kcoidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+	"github.com/openkop/oidc-go"
+	"gopkg.in/square/go-jose.v2"
+)
+
+type testSigner struct {
+	method     jwt.SigningMethod
+	kid        string
+	privateKey crypto.PrivateKey
+	publicKey  crypto.PublicKey
+}
+
+func newTestSigners(t testing.TB) []*testSigner {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edPublicKey, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return []*testSigner{
+		{jwt.SigningMethodRS256, "rsa", rsaKey, rsaKey.Public()},
+		{jwt.SigningMethodES256, "ec", ecKey, ecKey.Public()},
+		{SigningMethodEd25519, "ed", edKey, edPublicKey},
+	}
+}
+
+func newTestProvider(t testing.TB, signers []*testSigner) *Provider {
+	p, err := NewProvider(nil, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wellKnown := &oidc.WellKnown{}
+	jwks := &jose.JSONWebKeySet{}
+	for _, signer := range signers {
+		wellKnown.IDTokenSigningAlgValuesSupported = append(wellKnown.IDTokenSigningAlgValuesSupported, signer.method.Alg())
+		jwks.Keys = append(jwks.Keys, jose.JSONWebKey{
+			Key:       signer.publicKey,
+			KeyID:     signer.kid,
+			Algorithm: signer.method.Alg(),
+			Use:       "sig",
+		})
+	}
+	p.setDefinition(&oidc.ProviderDefinition{
+		WellKnown: wellKnown,
+		JWKS:      jwks,
+	})
+
+	return p
+}
+
+func (signer *testSigner) sign(t testing.TB, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(signer.method, claims)
+	token.Header["kid"] = signer.kid
+	tokenString, err := token.SignedString(signer.privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return tokenString
+}
+
+func newTestClaims() jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		IssuerClaim:    "https://issuer.example",
+		SubjectClaim:   "user1",
+		IssuedAtClaim:  now.Unix(),
+		ExpiresAtClaim: now.Add(time.Hour).Unix(),
+	}
+}
+
+func TestValidateTokenStringSigningMethods(t *testing.T) {
+	signers := newTestSigners(t)
+	p := newTestProvider(t, signers)
+	ctx := context.Background()
+
+	for _, signer := range signers {
+		tokenString := signer.sign(t, newTestClaims())
+		authenticatedUserID, _, _, err := p.ValidateTokenString(ctx, tokenString)
+		if err != nil {
+			t.Errorf("unexpected error for %s: %v", signer.method.Alg(), err)
+			continue
+		}
+		if authenticatedUserID != "user1" {
+			t.Errorf("unexpected user ID for %s: %v", signer.method.Alg(), authenticatedUserID)
+		}
+
+		// Tamper with the signature.
+		tampered := tokenString[:len(tokenString)-4] + "AAAA"
+		if _, _, _, err = p.ValidateTokenString(ctx, tampered); err != ErrStatusTokenInvalidSignature {
+			t.Errorf("unexpected error for tampered %s: %v", signer.method.Alg(), err)
+		}
+	}
+}
+
+func TestValidateTokenStringAllowedTokenTypes(t *testing.T) {
+	signers := newTestSigners(t)
+	p := newTestProvider(t, signers)
+	ctx := context.Background()
+
+	claims := newTestClaims()
+	claims[IsRefreshTokenClaim] = true
+	refreshTokenString := signers[0].sign(t, claims)
+	claims = newTestClaims()
+	claims[IsAccessTokenClaim] = true
+	accessTokenString := signers[0].sign(t, claims)
+
+	if _, _, _, err := p.ValidateTokenString(ctx, refreshTokenString); err != ErrStatusTokenTypeNotAllowed {
+		t.Errorf("unexpected error for refresh token by default: %v", err)
+	}
+	if _, _, _, err := p.ValidateTokenString(ctx, accessTokenString); err != nil {
+		t.Errorf("unexpected error for access token by default: %v", err)
+	}
+
+	// Per call override.
+	if _, _, _, err := p.ValidateTokenString(ctx, refreshTokenString, WithAllowedTokenTypes(TokenTypeKCRefresh)); err != nil {
+		t.Errorf("unexpected error for refresh token with option: %v", err)
+	}
+	if _, _, _, err := p.ValidateTokenString(ctx, accessTokenString, WithAllowedTokenTypes(TokenTypeKCRefresh)); err != ErrStatusTokenTypeNotAllowed {
+		t.Errorf("unexpected error for access token with option: %v", err)
+	}
+
+	// Provider override.
+	if err := p.SetAllowedTokenTypes(TokenTypeKCRefresh); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := p.ValidateTokenString(ctx, refreshTokenString); err != nil {
+		t.Errorf("unexpected error for refresh token with provider setting: %v", err)
+	}
+	if _, _, _, err := p.ValidateTokenString(ctx, accessTokenString); err != ErrStatusTokenTypeNotAllowed {
+		t.Errorf("unexpected error for access token with provider setting: %v", err)
+	}
+
+	// Reset to the defaults.
+	if err := p.SetAllowedTokenTypes(); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := p.ValidateTokenString(ctx, refreshTokenString); err != ErrStatusTokenTypeNotAllowed {
+		t.Errorf("unexpected error for refresh token after reset: %v", err)
+	}
+}
+
+func TestValidateTokenJWTAccessTokenType(t *testing.T) {
+	signers := newTestSigners(t)
+	p := newTestProvider(t, signers)
+	ctx := context.Background()
+
+	claims := newTestClaims()
+	claims[AudienceClaim] = []interface{}{"api1", "api2"}
+	claims[IDClaim] = "jti1"
+	claims[ClientIDClaim] = "client1"
+	plainTokenString := signers[0].sign(t, claims)
+	token := jwt.NewWithClaims(signers[0].method, claims)
+	token.Header["kid"] = signers[0].kid
+	token.Header["typ"] = JWTAccessTokenType
+	atTokenString, err := token.SignedString(signers[0].privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := p.ValidateToken(ctx, atTokenString)
+	if err != nil || result.TokenType != TokenTypeAccess {
+		t.Errorf("unexpected result for at+jwt token: %d, %v", result.TokenType, err)
+	}
+	result, err = p.ValidateToken(ctx, plainTokenString)
+	if err != nil || result.TokenType != TokenTypeStandard {
+		t.Errorf("unexpected result for plain token with client_id: %d, %v", result.TokenType, err)
+	}
+	if _, err = p.ValidateToken(ctx, atTokenString, WithAllowedTokenTypes(TokenTypeStandard)); err != ErrStatusTokenTypeNotAllowed {
+		t.Errorf("unexpected error for at+jwt token with standard tokens only: %v", err)
+	}
+	// Bound token validation reports the same token type.
+	result, err = p.ValidateCertificateBoundToken(ctx, atTokenString, nil)
+	if err != ErrStatusCertificateBindingMismatch || result.TokenType != TokenTypeAccess {
+		t.Errorf("unexpected result for certificate bound at+jwt token: %d, %v", result.TokenType, err)
+	}
+}
+
+func TestValidateTokenResult(t *testing.T) {
+	signers := newTestSigners(t)
+	p := newTestProvider(t, signers)
+	ctx := context.Background()
+
+	claims := newTestClaims()
+	claims[IdentityClaim] = map[string]interface{}{
+		IdentifiedUserIDClaim: "id1",
+		"groups":              []interface{}{"staff"},
+	}
+	result, err := p.ValidateToken(ctx, signers[1].sign(t, claims))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if result.Header["alg"] != "ES256" || result.Header["kid"] != signers[1].kid {
+		t.Errorf("unexpected header: %v", result.Header)
+	}
+	if result.Claims[IssuerClaim] != "https://issuer.example" || result.Claims[SubjectClaim] != "user1" {
+		t.Errorf("unexpected standard claims in claims: %v", result.Claims)
+	}
+	if _, ok := (*result.ExtraClaims)[SubjectClaim]; ok {
+		t.Errorf("unexpected standard claims in extra claims: %v", *result.ExtraClaims)
+	}
+	if result.AuthenticatedUserID != "id1" || result.StandardClaims.Subject != "user1" {
+		t.Errorf("unexpected result: %#v", result)
+	}
+
+	// Nested values of the claims must not be shared with the extra claims.
+	identity := (*result.ExtraClaims)[IdentityClaim].(map[string]interface{})
+	identity[IdentifiedUserIDClaim] = "changed"
+	identity["groups"].([]interface{})[0] = "changed"
+	identity = result.Claims[IdentityClaim].(map[string]interface{})
+	if identity[IdentifiedUserIDClaim] != "id1" || identity["groups"].([]interface{})[0] != "staff" {
+		t.Errorf("unexpected shared nested claims: %v", identity)
+	}
+}
+
+func TestValidateTokenStringEd25519JWKS(t *testing.T) {
+	signers := newTestSigners(t)
+	signer := signers[2]
+	p := newTestProvider(t, nil)
+	ctx := context.Background()
+
+	jwksJSON := fmt.Sprintf(`{"keys":[{"kty":"OKP","crv":"Ed25519","use":"sig","alg":"EdDSA","kid":"%s","x":"%s"}]}`,
+		signer.kid, base64.RawURLEncoding.EncodeToString(signer.publicKey.(ed25519.PublicKey)))
+	jwks := &jose.JSONWebKeySet{}
+	if err := json.Unmarshal([]byte(jwksJSON), jwks); err != nil {
+		t.Fatal(err)
+	}
+	p.setDefinition(&oidc.ProviderDefinition{
+		WellKnown: &oidc.WellKnown{
+			IDTokenSigningAlgValuesSupported: []string{"EdDSA"},
+		},
+		JWKS: jwks,
+	})
+
+	authenticatedUserID, _, _, err := p.ValidateTokenString(ctx, signer.sign(t, newTestClaims()))
+	if err != nil || authenticatedUserID != "user1" {
+		t.Errorf("unexpected result for EdDSA token: %v, %v", authenticatedUserID, err)
+	}
+	if _, _, _, err = p.ValidateTokenString(ctx, signers[0].sign(t, newTestClaims())); err != ErrStatusTokenInvalidSignature {
+		t.Errorf("unexpected error for RS256 token: %v", err)
+	}
+}
+
+func benchmarkValidateTokenString(b *testing.B, alg string) {
+	signers := newTestSigners(b)
+	p := newTestProvider(b, signers)
+	ctx := context.Background()
+
+	var tokenString string
+	for _, signer := range signers {
+		if signer.method.Alg() == alg {
+			tokenString = signer.sign(b, newTestClaims())
+		}
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, _, err := p.ValidateTokenString(ctx, tokenString); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkValidateTokenStringRS256(b *testing.B) {
+	benchmarkValidateTokenString(b, "RS256")
+}
+
+func BenchmarkValidateTokenStringES256(b *testing.B) {
+	benchmarkValidateTokenString(b, "ES256")
+}
+
+func BenchmarkValidateTokenStringEdDSA(b *testing.B) {
+	benchmarkValidateTokenString(b, "EdDSA")
+}
+
+func TestKeyPolicy(t *testing.T) {
+	weakKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signers := append(newTestSigners(t), &testSigner{jwt.SigningMethodRS256, "weak", weakKey, weakKey.Public()})
+	p := newTestProvider(t, signers)
+	ctx := context.Background()
+
+	if err = p.SetKeyPolicy(&KeyPolicy{
+		MinRSAKeySize: 2048,
+		AllowedCurves: []string{"P-384", "Ed25519"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, signer := range signers {
+		_, _, _, err = p.ValidateTokenString(ctx, signer.sign(t, newTestClaims()))
+		switch signer.kid {
+		case "weak", "ec":
+			if err != ErrStatusTokenKeyRejected {
+				t.Errorf("unexpected error for %s: %v", signer.kid, err)
+			}
+		default:
+			if err != nil {
+				t.Errorf("unexpected error for %s: %v", signer.kid, err)
+			}
+		}
+	}
+
+	for _, keyStatus := range p.Status().Keys {
+		rejected := keyStatus.KeyID == "weak" || keyStatus.KeyID == "ec"
+		if keyStatus.Accepted == rejected || (keyStatus.Reason != "") != rejected {
+			t.Errorf("unexpected key status: %#v", keyStatus)
+		}
+	}
+}
+
+func TestValidateTokenStringEncrypted(t *testing.T) {
+	signers := newTestSigners(t)
+	p := newTestProvider(t, signers)
+	ctx := context.Background()
+
+	decryptionKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypter, err := jose.NewEncrypter(jose.A256GCM, jose.Recipient{
+		Algorithm: jose.RSA_OAEP_256,
+		Key:       decryptionKey.Public(),
+		KeyID:     "enc",
+	}, (&jose.EncrypterOptions{}).WithContentType("JWT"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := encrypter.Encrypt([]byte(signers[0].sign(t, newTestClaims())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokenString, err := obj.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = p.ValidateToken(ctx, tokenString); err != ErrStatusTokenDecryptionFailed {
+		t.Errorf("unexpected error without decryption keys: %v", err)
+	}
+
+	if err = p.SetDecryptionKeys(jose.JSONWebKey{Key: decryptionKey, KeyID: "enc"}); err != nil {
+		t.Fatal(err)
+	}
+	result, err := p.ValidateToken(ctx, tokenString)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Encrypted || result.AuthenticatedUserID != "user1" {
+		t.Errorf("unexpected result: %#v", result)
+	}
+
+	// Compressed tokens are rejected before decryption.
+	compressingEncrypter, err := jose.NewEncrypter(jose.A256GCM, jose.Recipient{
+		Algorithm: jose.RSA_OAEP_256,
+		Key:       decryptionKey.Public(),
+		KeyID:     "enc",
+	}, &jose.EncrypterOptions{Compression: jose.DEFLATE})
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err = compressingEncrypter.Encrypt([]byte(signers[0].sign(t, newTestClaims())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressedTokenString, err := obj.CompactSerialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.ValidateToken(ctx, compressedTokenString); err != ErrStatusTokenDecryptionFailed {
+		t.Errorf("unexpected error for compressed token: %v", err)
+	}
+
+	// Token limits apply to encrypted tokens.
+	if err = p.SetTokenLimits(len(tokenString)-1, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = p.ValidateToken(ctx, tokenString); err != ErrStatusTokenTooLarge {
+		t.Errorf("unexpected error for oversized token: %v", err)
+	}
+}
+
+func TestValidateDPoPTokenString(t *testing.T) {
+	signers := newTestSigners(t)
+	p := newTestProvider(t, signers)
+	ctx := context.Background()
+
+	proofKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk := jose.JSONWebKey{Key: proofKey.Public()}
+	jwkJSON, _ := jwk.MarshalJSON()
+	var jwkHeader map[string]interface{}
+	_ = json.Unmarshal(jwkJSON, &jwkHeader)
+	thumbprint, _ := jwk.Thumbprint(crypto.SHA256)
+
+	claims := newTestClaims()
+	claims[ConfirmationClaim] = map[string]interface{}{
+		JWKThumbprintConfirmationClaim: base64.RawURLEncoding.EncodeToString(thumbprint),
+	}
+	tokenString := signers[0].sign(t, claims)
+	ath := sha256.Sum256([]byte(tokenString))
+
+	newProof := func(jti string, htm string) string {
+		proof := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+			IDClaim:                  jti,
+			IssuedAtClaim:            time.Now().Unix(),
+			DPoPHTTPMethodClaim:      htm,
+			DPoPHTTPURIClaim:         "https://rs.example/api",
+			DPoPAccessTokenHashClaim: base64.RawURLEncoding.EncodeToString(ath[:]),
+		})
+		proof.Header["typ"] = DPoPProofType
+		proof.Header["jwk"] = jwkHeader
+		proofString, signErr := proof.SignedString(proofKey)
+		if signErr != nil {
+			t.Fatal(signErr)
+		}
+		return proofString
+	}
+
+	if _, _, _, err = p.ValidateDPoPTokenString(ctx, tokenString, newProof("1", "GET"), "GET", "https://rs.example/api?q=1"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, _, _, err = p.ValidateDPoPTokenString(ctx, tokenString, newProof("1", "GET"), "GET", "https://rs.example/api"); err != ErrStatusDPoPProofReplayed {
+		t.Errorf("unexpected error for replay: %v", err)
+	}
+	if _, _, _, err = p.ValidateDPoPTokenString(ctx, tokenString, newProof("2", "POST"), "GET", "https://rs.example/api"); !errors.Is(err, ErrStatusInvalidDPoPProof) {
+		t.Errorf("unexpected error for htm mismatch: %v", err)
+	}
+
+	unboundTokenString := signers[0].sign(t, newTestClaims())
+	ath = sha256.Sum256([]byte(unboundTokenString))
+	if _, _, _, err = p.ValidateDPoPTokenString(ctx, unboundTokenString, newProof("3", "GET"), "GET", "https://rs.example/api"); err != ErrStatusDPoPBindingMismatch {
+		t.Errorf("unexpected error for unbound token: %v", err)
+	}
+}
+
+func TestValidateCertificateBoundTokenString(t *testing.T) {
+	signers := newTestSigners(t)
+	p := newTestProvider(t, signers)
+	ctx := context.Background()
+
+	newCertificate := func() *x509.Certificate {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template := &x509.Certificate{
+			SerialNumber: big.NewInt(1),
+			NotBefore:    time.Now(),
+			NotAfter:     time.Now().Add(time.Hour),
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	cert := newCertificate()
+
+	claims := newTestClaims()
+	claims[ConfirmationClaim] = map[string]interface{}{
+		X509CertificateThumbprintConfirmationClaim: CertificateThumbprint(cert),
+	}
+	tokenString := signers[0].sign(t, claims)
+
+	if _, _, _, err := p.ValidateCertificateBoundTokenString(ctx, tokenString, cert); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if _, _, _, err := p.ValidateCertificateBoundTokenString(ctx, tokenString, newCertificate()); err != ErrStatusCertificateBindingMismatch {
+		t.Errorf("unexpected error for other certificate: %v", err)
+	}
+	if _, _, _, err := p.ValidateCertificateBoundTokenString(ctx, signers[0].sign(t, newTestClaims()), cert); err != ErrStatusCertificateBindingMismatch {
+		t.Errorf("unexpected error for unbound token: %v", err)
+	}
+}
+
+func TestValidateTokenStringHeaderAndLimits(t *testing.T) {
+	signers := newTestSigners(t)
+	p := newTestProvider(t, signers)
+	ctx := context.Background()
+
+	signWithHeader := func(header map[string]interface{}, claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(signers[0].method, claims)
+		token.Header["kid"] = signers[0].kid
+		for k, v := range header {
+			token.Header[k] = v
+		}
+		tokenString, err := token.SignedString(signers[0].privateKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tokenString
+	}
+
+	for _, test := range []struct {
+		header map[string]interface{}
+		err    error
+	}{
+		{map[string]interface{}{}, nil},
+		{map[string]interface{}{"jku": "https://attacker.example.com/jwks"}, ErrStatusTokenEmbeddedKey},
+		{map[string]interface{}{"x5u": "https://attacker.example.com/cert"}, ErrStatusTokenEmbeddedKey},
+		{map[string]interface{}{"jwk": map[string]interface{}{"kty": "EC"}}, ErrStatusTokenEmbeddedKey},
+		{map[string]interface{}{"x5c": []interface{}{"MIIBattacker"}}, ErrStatusTokenEmbeddedKey},
+		{map[string]interface{}{"crit": []interface{}{"exp"}, "exp": 1}, ErrStatusTokenUnsupportedCritical},
+		{map[string]interface{}{"crit": []interface{}{}}, ErrStatusTokenUnsupportedCritical},
+	} {
+		if _, _, _, err := p.ValidateTokenString(ctx, signWithHeader(test.header, newTestClaims())); err != test.err {
+			t.Errorf("unexpected error for header %v: %v", test.header, err)
+		}
+	}
+
+	claims := newTestClaims()
+	nested := map[string]interface{}{}
+	claims["nested"] = nested
+	for i := 0; i < DefaultMaxClaimsDepth; i++ {
+		next := map[string]interface{}{}
+		nested["n"] = next
+		nested = next
+	}
+	tokenString := signWithHeader(nil, claims)
+	if _, _, _, err := p.ValidateTokenString(ctx, tokenString); err != ErrStatusTokenClaimsTooDeep {
+		t.Errorf("unexpected error for deep claims: %v", err)
+	}
+	if err := p.SetTokenLimits(len(tokenString)-1, DefaultMaxClaimsDepth+2); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := p.ValidateTokenString(ctx, tokenString); err != ErrStatusTokenTooLarge {
+		t.Errorf("unexpected error for large token: %v", err)
+	}
+	if err := p.SetTokenLimits(len(tokenString), DefaultMaxClaimsDepth+2); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := p.ValidateTokenString(ctx, tokenString); err != nil {
+		t.Errorf("unexpected error with raised limits: %v", err)
+	}
+	if err := p.SetTokenLimits(-1, 0); err != ErrStatusInvalidTokenLimits {
+		t.Errorf("unexpected error for negative limits: %v", err)
+	}
+}
+
+func TestKeyPolicyCertificateRoots(t *testing.T) {
+	newCertificate := func(subject string, publicKey crypto.PublicKey, parent *x509.Certificate, parentKey crypto.PrivateKey) *x509.Certificate {
+		template := &x509.Certificate{
+			SerialNumber:          big.NewInt(1),
+			Subject:               pkix.Name{CommonName: subject},
+			NotBefore:             time.Now().Add(-time.Hour),
+			NotAfter:              time.Now().Add(time.Hour),
+			IsCA:                  parent == nil,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		}
+		if parent == nil {
+			parent = template
+		}
+		der, err := x509.CreateCertificate(rand.Reader, template, parent, publicKey, parentKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca := newCertificate("ca", caKey.Public(), nil, caKey)
+	otherCAKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherCA := newCertificate("other", otherCAKey.Public(), nil, otherCAKey)
+
+	signers := newTestSigners(t)
+	roots := x509.NewCertPool()
+	roots.AddCert(ca)
+	policy := &KeyPolicy{
+		CertificateRoots: roots,
+	}
+
+	for _, test := range []struct {
+		name         string
+		certificates []*x509.Certificate
+		valid        bool
+	}{
+		{"valid", []*x509.Certificate{newCertificate("leaf", signers[0].publicKey, ca, caKey)}, true},
+		{"none", nil, false},
+		{"untrusted", []*x509.Certificate{newCertificate("leaf", signers[0].publicKey, otherCA, otherCAKey)}, false},
+		{"mismatch", []*x509.Certificate{newCertificate("leaf", signers[1].publicKey, ca, caKey)}, false},
+	} {
+		err = policy.Check(&jose.JSONWebKey{
+			Key:          signers[0].publicKey,
+			KeyID:        signers[0].kid,
+			Certificates: test.certificates,
+		})
+		if (err == nil) != test.valid {
+			t.Errorf("unexpected result for %s: %v", test.name, err)
+		}
+	}
+}
+
+func TestExtraAndPinnedKeys(t *testing.T) {
+	signers := newTestSigners(t)
+	p := newTestProvider(t, signers[:1])
+	ctx := context.Background()
+	if err := p.SetSigningAlgs([]string{signers[0].method.Alg(), signers[1].method.Alg()}, nil, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, _, err := p.ValidateTokenString(ctx, signers[0].sign(t, newTestClaims())); err != nil {
+		t.Errorf("unexpected error for JWKS key: %v", err)
+	}
+	if _, _, _, err := p.ValidateTokenString(ctx, signers[1].sign(t, newTestClaims())); err != ErrStatusTokenUnknownKey {
+		t.Errorf("unexpected error for unknown key: %v", err)
+	}
+
+	extraKey := jose.JSONWebKey{
+		Key:   signers[1].publicKey,
+		KeyID: signers[1].kid,
+	}
+	if err := p.SetExtraKeys(extraKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := p.ValidateTokenString(ctx, signers[1].sign(t, newTestClaims())); err != nil {
+		t.Errorf("unexpected error for extra key: %v", err)
+	}
+
+	if err := p.SetPinnedKeys(keyThumbprint(&extraKey)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := p.ValidateTokenString(ctx, signers[0].sign(t, newTestClaims())); err != ErrStatusTokenKeyRejected {
+		t.Errorf("unexpected error for not pinned key: %v", err)
+	}
+	if _, _, _, err := p.ValidateTokenString(ctx, signers[1].sign(t, newTestClaims())); err != nil {
+		t.Errorf("unexpected error for pinned key: %v", err)
+	}
+	if err := p.SetPinnedKeys("invalid"); err == nil {
+		t.Errorf("expected error for invalid thumbprint")
+	}
+}
+
+func TestKeyGracePeriod(t *testing.T) {
+	signers := newTestSigners(t)
+	p := newTestProvider(t, signers)
+	ctx := context.Background()
+	if err := p.SetKeyGracePeriod(time.Hour, 10*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	claims := newTestClaims()
+	claims[IssuedAtClaim] = time.Now().Add(-2 * time.Minute).Unix()
+	tokenString := signers[0].sign(t, claims)
+	laterTokenString := signers[0].sign(t, newTestClaims())
+
+	// Remove the first key from the JWKS, as if it happened a minute ago.
+	p.mutex.Lock()
+	p.setDefinition(&oidc.ProviderDefinition{
+		WellKnown: p.definition.WellKnown,
+		JWKS: &jose.JSONWebKeySet{
+			Keys: p.definition.JWKS.Keys[1:],
+		},
+	})
+	p.retiredKeys[0].retiredAt = p.retiredKeys[0].retiredAt.Add(-time.Minute)
+	p.mutex.Unlock()
+
+	if _, _, _, err := p.ValidateTokenString(ctx, tokenString); err != nil {
+		t.Errorf("unexpected error for retired key: %v", err)
+	}
+	if _, _, _, err := p.ValidateTokenString(ctx, laterTokenString); err != ErrStatusTokenKeyRejected {
+		t.Errorf("unexpected error for token issued after retirement: %v", err)
+	}
+
+	status := p.Status()
+	if status.RetiredKeyValidations != 1 {
+		t.Errorf("unexpected retired key validations: %d", status.RetiredKeyValidations)
+	}
+	retired := 0
+	for _, keyStatus := range status.Keys {
+		if keyStatus.Source == KeySourceRetired {
+			retired++
+			if keyStatus.KeyID != signers[0].kid || keyStatus.RetiredUntil <= keyStatus.RetiredAt {
+				t.Errorf("unexpected retired key status: %#v", keyStatus)
+			}
+		}
+	}
+	if retired != 1 {
+		t.Errorf("unexpected number of retired keys: %d", retired)
+	}
+
+	if err := p.SetKeyGracePeriod(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, _, err := p.ValidateTokenString(ctx, tokenString); err != ErrStatusTokenUnknownKey {
+		t.Errorf("unexpected error for removed key: %v", err)
+	}
+}
+
+func TestKeyGracePeriodReinitialize(t *testing.T) {
+	signers := newTestSigners(t)
+
+	var mutex sync.Mutex
+	current := signers[0]
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		rw.Header().Set("Content-Type", "application/json")
+		switch req.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(rw).Encode(&oidc.WellKnown{
+				Issuer:                           server.URL,
+				JwksURI:                          server.URL + "/jwks.json",
+				IDTokenSigningAlgValuesSupported: []string{"RS256", "ES256"},
+			})
+		case "/jwks.json":
+			mutex.Lock()
+			defer mutex.Unlock()
+			_ = json.NewEncoder(rw).Encode(&jose.JSONWebKeySet{
+				Keys: []jose.JSONWebKey{{Key: current.publicKey, KeyID: current.kid}},
+			})
+		default:
+			http.NotFound(rw, req)
+		}
+	}))
+	defer server.Close()
+
+	p, err := NewProvider(server.Client(), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.SetKeyGracePeriod(time.Hour, 0); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	issuer, _ := url.Parse(server.URL)
+
+	// Initialize twice with different keys, the keys of the first
+	// initialization must not be retired by the second one.
+	for i := 0; i < 2; i++ {
+		mutex.Lock()
+		current = signers[i]
+		mutex.Unlock()
+		if err = p.Initialize(ctx, issuer); err != nil {
+			t.Fatal(err)
+		}
+		if err = p.WaitUntilReady(ctx, 10*time.Second); err != nil {
+			t.Fatal(err)
+		}
+		for _, keyStatus := range p.Status().Keys {
+			if keyStatus.Source == KeySourceRetired {
+				t.Errorf("unexpected retired key after initialize %d: %#v", i+1, keyStatus)
+			}
+		}
+		if err = p.Uninitialize(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestKeyGracePeriodSameKeyID(t *testing.T) {
+	signers := newTestSigners(t)
+	p := newTestProvider(t, signers[:1])
+	ctx := context.Background()
+	if err := p.SetKeyGracePeriod(-time.Hour, 0); err != ErrStatusInvalidKeyGracePeriod {
+		t.Errorf("unexpected error for negative grace period: %v", err)
+	}
+	if err := p.SetKeyGracePeriod(time.Hour, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	claims := newTestClaims()
+	claims[IssuedAtClaim] = time.Now().Add(-2 * time.Minute).Unix()
+	tokenString := signers[0].sign(t, claims)
+
+	// Rotate the key without changing its key ID.
+	rotated := newTestSigners(t)[0]
+	p.mutex.Lock()
+	p.setDefinition(&oidc.ProviderDefinition{
+		WellKnown: p.definition.WellKnown,
+		JWKS: &jose.JSONWebKeySet{
+			Keys: []jose.JSONWebKey{{
+				Key:       rotated.publicKey,
+				KeyID:     rotated.kid,
+				Algorithm: rotated.method.Alg(),
+				Use:       "sig",
+			}},
+		},
+	})
+	p.retiredKeys[0].retiredAt = p.retiredKeys[0].retiredAt.Add(-time.Minute)
+	p.mutex.Unlock()
+
+	if _, _, _, err := p.ValidateTokenString(ctx, tokenString); err != nil {
+		t.Errorf("unexpected error for retired key with same key ID: %v", err)
+	}
+	if _, _, _, err := p.ValidateTokenString(ctx, rotated.sign(t, newTestClaims())); err != nil {
+		t.Errorf("unexpected error for current key with same key ID: %v", err)
+	}
+	if _, _, _, err := p.ValidateTokenString(ctx, signers[0].sign(t, newTestClaims())); err != ErrStatusTokenKeyRejected {
+		t.Errorf("unexpected error for token issued after retirement: %v", err)
+	}
+	if status := p.Status(); status.RetiredKeyValidations != 1 {
+		t.Errorf("unexpected retired key validations: %d", status.RetiredKeyValidations)
+	}
+}
+
+func TestStalenessPolicy(t *testing.T) {
+	signers := newTestSigners(t)
+	p := newTestProvider(t, signers)
+	ctx := context.Background()
+	tokenString := signers[0].sign(t, newTestClaims())
+
+	if err := p.SetStalenessPolicy(time.Hour, StalenessPolicyDeny); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetStalenessPolicy(time.Hour, -1); err != ErrStatusInvalidStalenessPolicy {
+		t.Errorf("unexpected error for invalid policy: %v", err)
+	}
+	if err := checkDefinition(&oidc.ProviderDefinition{WellKnown: &oidc.WellKnown{}, JWKS: &jose.JSONWebKeySet{}}, nil); err == nil {
+		t.Errorf("expected error for definition without keys")
+	}
+	if err := checkDefinition(&oidc.ProviderDefinition{WellKnown: &oidc.WellKnown{}, JWKS: &jose.JSONWebKeySet{}}, []jose.JSONWebKey{{Key: signers[0].publicKey, KeyID: signers[0].kid}}); err != nil {
+		t.Errorf("unexpected error for definition without keys but with extra keys: %v", err)
+	}
+
+	now := time.Now()
+	p.mutex.Lock()
+	p.lastUpdated = now.Add(-30 * time.Minute)
+	p.refreshFailed(fmt.Errorf("refresh failed"), now.Add(-time.Minute))
+	p.mutex.Unlock()
+	if _, _, _, err := p.ValidateTokenString(ctx, tokenString); err != nil {
+		t.Errorf("unexpected error while stale: %v", err)
+	}
+	if status := p.Status(); !status.Stale || status.StalenessExceeded || status.LastError == "" || status.StaleSeconds < 30*60 {
+		t.Errorf("unexpected status while stale: %#v", status)
+	}
+
+	p.mutex.Lock()
+	p.lastUpdated = now.Add(-2 * time.Hour)
+	p.mutex.Unlock()
+	if _, _, _, err := p.ValidateTokenString(ctx, tokenString); err != ErrStatusProviderStale {
+		t.Errorf("unexpected error when staleness exceeded: %v", err)
+	}
+	if status := p.Status(); !status.StalenessExceeded {
+		t.Errorf("unexpected status when staleness exceeded: %#v", status)
+	}
+
+	p.mutex.Lock()
+	p.refreshSucceeded(now)
+	p.mutex.Unlock()
+	if _, _, _, err := p.ValidateTokenString(ctx, tokenString); err != nil {
+		t.Errorf("unexpected error after refresh: %v", err)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	signers := newTestSigners(t)
+
+	var mutex sync.Mutex
+	current := signers[:1]
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		rw.Header().Set("Content-Type", "application/json")
+		switch req.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(rw).Encode(&oidc.WellKnown{
+				Issuer:                           server.URL,
+				JwksURI:                          server.URL + "/jwks.json",
+				IDTokenSigningAlgValuesSupported: []string{"RS256", "ES256"},
+			})
+		case "/jwks.json":
+			jwks := &jose.JSONWebKeySet{}
+			for _, signer := range current {
+				jwks.Keys = append(jwks.Keys, jose.JSONWebKey{Key: signer.publicKey, KeyID: signer.kid})
+			}
+			_ = json.NewEncoder(rw).Encode(jwks)
+		default:
+			http.NotFound(rw, req)
+		}
+	}))
+	defer server.Close()
+
+	p, err := NewProvider(server.Client(), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if _, err = p.Refresh(ctx); err != ErrStatusNotInitialized {
+		t.Errorf("unexpected error before initialize: %v", err)
+	}
+	issuer, _ := url.Parse(server.URL)
+	if err = p.Initialize(ctx, issuer); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Uninitialize()
+	if err = p.WaitUntilReady(ctx, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := p.Refresh(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Changed {
+		t.Errorf("unexpected changes: %#v", result)
+	}
+
+	mutex.Lock()
+	current = signers[1:2]
+	mutex.Unlock()
+	result, err = p.Refresh(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Changed || result.DiscoveryChanged || len(result.KeysAdded) != 1 || result.KeysAdded[0] != signers[1].kid || len(result.KeysRemoved) != 1 || result.KeysRemoved[0] != signers[0].kid {
+		t.Errorf("unexpected changes after key rotation: %#v", result)
+	}
+	if _, _, _, err = p.ValidateTokenString(ctx, signers[1].sign(t, newTestClaims())); err != nil {
+		t.Errorf("unexpected error for rotated key: %v", err)
+	}
+}
+
+func TestRefreshPolicyDelay(t *testing.T) {
+	policy := &RefreshPolicy{
+		Interval:   time.Minute,
+		Jitter:     10 * time.Second,
+		MinBackoff: time.Second,
+		MaxBackoff: 5 * time.Second,
+	}
+	for i := 0; i < 10; i++ {
+		if delay := policy.delay(0, time.Time{}); delay < 50*time.Second || delay > 70*time.Second {
+			t.Errorf("unexpected delay: %v", delay)
+		}
+	}
+	for failures, expected := range []time.Duration{time.Minute, time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second} {
+		if failures == 0 {
+			continue
+		}
+		if delay := policy.delay(failures, time.Time{}); delay != expected {
+			t.Errorf("unexpected backoff after %d failures: %v", failures, delay)
+		}
+	}
+
+	// Without interval, HTTP caching defines when to refresh.
+	var cachingPolicy *RefreshPolicy
+	if delay := cachingPolicy.delay(0, time.Now().Add(10*time.Minute)); delay < 9*time.Minute || delay > 10*time.Minute {
+		t.Errorf("unexpected delay for cached definition: %v", delay)
+	}
+	if delay := cachingPolicy.delay(0, time.Time{}); delay != DefaultRefreshInterval {
+		t.Errorf("unexpected delay for uncached definition: %v", delay)
+	}
+	if delay := cachingPolicy.delay(10, time.Time{}); delay != DefaultRefreshInterval {
+		t.Errorf("unexpected backoff for uncached definition: %v", delay)
+	}
+	if delay := (&RefreshPolicy{MaxBackoff: time.Hour}).delay(0, time.Now().Add(time.Second)); delay != DefaultRefreshInterval {
+		t.Errorf("unexpected delay for definition which expires immediately: %v", delay)
+	}
+}
+
+func TestSetRefreshPolicy(t *testing.T) {
+	p, err := NewProvider(nil, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, policy := range []*RefreshPolicy{
+		{Interval: -time.Second},
+		{Interval: time.Minute, MaxBackoff: -time.Second},
+		{Interval: time.Minute, Jitter: time.Minute},
+		{Jitter: time.Second},
+	} {
+		if err = p.SetRefreshPolicy(policy); !errors.Is(err, ErrStatusInvalidRefreshPolicy) {
+			t.Errorf("unexpected error for %#v: %v", policy, err)
+		}
+	}
+	if err = p.SetRefreshPolicy(&RefreshPolicy{Interval: time.Minute, Jitter: time.Second}); err != nil {
+		t.Errorf("unexpected error for valid policy: %v", err)
+	}
+	if err = p.SetRefreshPolicy(nil); err != nil {
+		t.Errorf("unexpected error for nil policy: %v", err)
+	}
+}
+
+func TestRefreshLoop(t *testing.T) {
+	signers := newTestSigners(t)
+
+	var mutex sync.Mutex
+	current := signers[:1]
+	fetches := 0
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		rw.Header().Set("Content-Type", "application/json")
+		switch req.URL.Path {
+		case "/.well-known/openid-configuration":
+			_ = json.NewEncoder(rw).Encode(&oidc.WellKnown{
+				Issuer:                           server.URL,
+				JwksURI:                          server.URL + "/jwks.json",
+				IDTokenSigningAlgValuesSupported: []string{"RS256", "ES256"},
+			})
+		case "/jwks.json":
+			fetches++
+			// The policy takes precedence over HTTP caching.
+			rw.Header().Set("Cache-Control", "max-age=3600")
+			jwks := &jose.JSONWebKeySet{}
+			for _, signer := range current {
+				jwks.Keys = append(jwks.Keys, jose.JSONWebKey{Key: signer.publicKey, KeyID: signer.kid})
+			}
+			_ = json.NewEncoder(rw).Encode(jwks)
+		default:
+			http.NotFound(rw, req)
+		}
+	}))
+	defer server.Close()
+	getFetches := func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return fetches
+	}
+
+	p, err := NewProvider(server.Client(), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.SetRefreshPolicy(&RefreshPolicy{Interval: 20 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	issuer, _ := url.Parse(server.URL)
+	if err = p.Initialize(ctx, issuer); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.WaitUntilReady(ctx, 5*time.Second); err != nil {
+		t.Fatal(err)
+	}
+
+	mutex.Lock()
+	current = signers[1:2]
+	mutex.Unlock()
+	tokenString := signers[1].sign(t, newTestClaims())
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, _, _, err = p.ValidateTokenString(ctx, tokenString); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("rotated key not refreshed: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if err = p.Uninitialize(); err != nil {
+		t.Fatal(err)
+	}
+	count := getFetches()
+	if count < 2 {
+		t.Errorf("unexpected number of fetches: %d", count)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if getFetches() != count {
+		t.Errorf("unexpected fetches after uninitialize")
+	}
+}
+
+func TestInitializeErrors(t *testing.T) {
+	signers := newTestSigners(t)
+
+	var mutex sync.Mutex
+	response := "unavailable"
+	fetches := 0
+	setResponse := func(value string) {
+		mutex.Lock()
+		response = value
+		mutex.Unlock()
+	}
+	getFetches := func() int {
+		mutex.Lock()
+		defer mutex.Unlock()
+		return fetches
+	}
+	var server *httptest.Server
+	server = httptest.NewTLSServer(http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		rw.Header().Set("Content-Type", "application/json")
+		switch {
+		case response == "unavailable":
+			rw.WriteHeader(http.StatusServiceUnavailable)
+		case response == "garbage":
+			_, _ = rw.Write([]byte("{garbage"))
+		case req.URL.Path == "/.well-known/openid-configuration":
+			fetches++
+			_ = json.NewEncoder(rw).Encode(&oidc.WellKnown{
+				Issuer:                           server.URL,
+				JwksURI:                          server.URL + "/jwks.json",
+				IDTokenSigningAlgValuesSupported: []string{"RS256"},
+			})
+		case req.URL.Path == "/jwks.json":
+			_ = json.NewEncoder(rw).Encode(&jose.JSONWebKeySet{
+				Keys: []jose.JSONWebKey{{Key: signers[0].publicKey, KeyID: signers[0].kid}},
+			})
+		}
+	}))
+	defer server.Close()
+	issuer, _ := url.Parse(server.URL)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newProvider := func(client *http.Client, retry bool) *Provider {
+		p, err := NewProvider(client, nil, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = p.SetInitializeRetry(retry, 10*time.Millisecond, 20*time.Millisecond); err != nil {
+			t.Fatal(err)
+		}
+		return p
+	}
+
+	// Fail fast when retrying is disabled.
+	if err := newProvider(http.DefaultClient, false).Initialize(ctx, issuer); !errors.Is(err, ErrStatusTLSFailure) {
+		t.Errorf("unexpected error for untrusted certificate: %v", err)
+	}
+	if err := newProvider(server.Client(), false).Initialize(ctx, issuer); !errors.Is(err, ErrStatusHTTPFailure) {
+		t.Errorf("unexpected error for unavailable server: %v", err)
+	}
+	setResponse("garbage")
+	if err := newProvider(server.Client(), false).Initialize(ctx, issuer); !errors.Is(err, ErrStatusInvalidProviderResponse) {
+		t.Errorf("unexpected error for garbage response: %v", err)
+	}
+	if status := fetchErrorStatus(&url.Error{Op: "Get", URL: "https://unknown.invalid", Err: &net.DNSError{Err: "no such host", Name: "unknown.invalid"}}); status != ErrStatusDNSFailure {
+		t.Errorf("unexpected status for DNS error: %v", status)
+	}
+	if status := fetchErrorStatus(&url.Error{Op: "Get", URL: "https://example.com", Err: x509.HostnameError{Host: "example.com"}}); status != ErrStatusTLSFailure {
+		t.Errorf("unexpected status for TLS error: %v", status)
+	}
+	if status := fetchErrorStatus(&url.Error{Op: "Get", URL: "https://example.com", Err: errors.New("tls: handshake failure")}); status != ErrStatusHTTPFailure {
+		t.Errorf("unexpected status for untyped error: %v", status)
+	}
+
+	// The definition is fetched once during initialize.
+	setResponse("ok")
+	p := newProvider(server.Client(), false)
+	if err := p.Initialize(ctx, issuer); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	if count := getFetches(); count != 1 {
+		t.Errorf("unexpected number of fetches after initialize: %d", count)
+	}
+	if err := p.Uninitialize(); err != nil {
+		t.Errorf("unexpected error for uninitialize: %v", err)
+	}
+
+	// Retry in the background by default.
+	setResponse("unavailable")
+	p, err := NewProvider(server.Client(), nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = p.Initialize(ctx, issuer); err != nil {
+		t.Fatalf("unexpected error with retry: %v", err)
+	}
+	defer p.Uninitialize()
+	if err = p.WaitUntilReady(ctx, 50*time.Millisecond); !errors.Is(err, ErrStatusHTTPFailure) {
+		t.Errorf("unexpected error while retrying: %v", err)
+	}
+	if status := p.Status(); status.Ready || status.LastError == "" {
+		t.Errorf("unexpected status while retrying: %#v", status)
+	}
+	setResponse("ok")
+	if err = p.WaitUntilReady(ctx, 10*time.Second); err != nil {
+		t.Errorf("unexpected error after retry: %v", err)
+	}
+	if status := p.Status(); !status.Ready || status.LastError != "" {
+		t.Errorf("unexpected status after retry: %#v", status)
+	}
+	if _, _, _, err = p.ValidateTokenString(ctx, signers[0].sign(t, newTestClaims())); err != nil {
+		t.Errorf("unexpected error after retry: %v", err)
+	}
+}
@@ -4,6 +4,7 @@
  */
 
 #include <Python.h>
+#include <string.h>
 #include <kcoidc.h>
 
 #if PY_MAJOR_VERSION >= 3
@@ -14,19 +15,414 @@
 #define WITH_REQUIRE_SCOPE
 #endif
 
+#if KCOIDC_VERSION >= 10300
+#define WITH_TOKEN_TYPES
+#define WITH_REQUIRE_ROLE
+#define WITH_REQUIRE_SCOPES
+#define WITH_REQUIRE_AUTHORIZED_CLAIMS
+#define WITH_KONNECT_IDENTITY
+#define WITH_STATUS
+#define WITH_REFRESH
+#define WITH_DPOP
+#define WITH_MTLS
+#endif
+
 static PyObject *PyKCOIDCError;
 
+#if defined(WITH_REQUIRE_SCOPES) || defined(WITH_REQUIRE_AUTHORIZED_CLAIMS)
+// Sets the error with the status code and the list of the space separated
+// values of the provided string, like missing scopes, as its arguments. The
+// provided string is modified.
+static void
+pykcoidc_set_error_with_fields(unsigned long long code, char *fields_s)
+{
+	PyObject *fields;
+	PyObject *item;
+	PyObject *value;
+	char *field;
+
+	fields = PyList_New(0);
+	if (fields == NULL)
+		return;
+	for (field = strtok(fields_s, " "); field != NULL; field = strtok(NULL, " ")) {
+		item = Py_BuildValue("s", field);
+		if (item == NULL || PyList_Append(fields, item) != 0) {
+			Py_XDECREF(item);
+			Py_DECREF(fields);
+			return;
+		}
+		Py_DECREF(item);
+	}
+
+	value = Py_BuildValue("(KN)", code, fields);
+	if (value == NULL)
+		return;
+	PyErr_SetObject(PyKCOIDCError, value);
+	Py_DECREF(value);
+}
+#endif
+
+static PyObject *
+pykcoidc_initialize(PyObject *self, PyObject *args)
+{
+	char *iss_s;
+	int res;
+
+	if (!PyArg_ParseTuple(args, "s", &iss_s))
+		return NULL;
+
+	Py_BEGIN_ALLOW_THREADS;
+	res = kcoidc_initialize(iss_s);
+	Py_END_ALLOW_THREADS;
+
+	if (res != 0) {
+		PyErr_SetObject(PyKCOIDCError, PyLong_FromLong(res));
+		return NULL;
+	}
+
+	return PyLong_FromLong(res);
+}
+
+static PyObject *
+pykcoidc_wait_until_ready(PyObject *self, PyObject *args)
+{
+	unsigned long long timeout;
+	int res;
+
+	if (!PyArg_ParseTuple(args, "K", &timeout))
+		return NULL;
+
+	Py_BEGIN_ALLOW_THREADS;
+	res = kcoidc_wait_until_ready(timeout);
+	Py_END_ALLOW_THREADS;
+
+	if (res != 0) {
+		PyErr_SetObject(PyKCOIDCError, PyLong_FromLong(res));
+		return NULL;
+	}
+
+	return PyLong_FromLong(res);
+}
+
+static PyObject *
+pykcoidc_insecure_skip_verify(PyObject *self, PyObject *args)
+{
+	int insecure;
+	int res;
+
+	if (!PyArg_ParseTuple(args, "i", &insecure))
+		return NULL;
+
+	Py_BEGIN_ALLOW_THREADS;
+	res = kcoidc_insecure_skip_verify(insecure);
+	Py_END_ALLOW_THREADS;
+
+	if (res != 0) {
+		PyErr_SetObject(PyKCOIDCError, PyLong_FromLong(res));
+		return NULL;
+	}
+
+	return PyLong_FromLong(res);
+}
+
+static PyObject *
+pykcoidc_validate_token_s(PyObject *self, PyObject *args)
+{
+	PyObject *res = NULL;
+	char *token_s;
+	struct kcoidc_validate_token_s_return token_result;
+
+	if (!PyArg_ParseTuple(args, "s", &token_s))
+		return NULL;
+
+	Py_BEGIN_ALLOW_THREADS;
+	token_result = kcoidc_validate_token_s(token_s);
+	Py_END_ALLOW_THREADS;
+
+	if (token_result.r1 != 0) {
+		PyErr_SetObject(PyKCOIDCError, PyLong_FromLong(token_result.r1));
+	} else {
+		res = Py_BuildValue("zizz", token_result.r0, token_result.r2, token_result.r3, token_result.r4);
+	}
+
+	// Free the strings passed from the library.
+	free(token_result.r0);
+	free(token_result.r3);
+	free(token_result.r4);
+
+	return res;
+}
+
+#ifdef WITH_TOKEN_TYPES
+static PyObject *
+pykcoidc_set_allowed_token_types(PyObject *self, PyObject *args)
+{
+	int token_types_mask;
+	int res;
+
+	if (!PyArg_ParseTuple(args, "i", &token_types_mask))
+		return NULL;
+
+	Py_BEGIN_ALLOW_THREADS;
+	res = kcoidc_set_allowed_token_types(token_types_mask);
+	Py_END_ALLOW_THREADS;
+
+	if (res != 0) {
+		PyErr_SetObject(PyKCOIDCError, PyLong_FromLong(res));
+		return NULL;
+	}
+
+	return PyLong_FromLong(res);
+}
+
+static PyObject *
+pykcoidc_set_require_jwt_access_token_type(PyObject *self, PyObject *args)
+{
+	int required;
+	int res;
+
+	if (!PyArg_ParseTuple(args, "i", &required))
+		return NULL;
+
+	Py_BEGIN_ALLOW_THREADS;
+	res = kcoidc_set_require_jwt_access_token_type(required);
+	Py_END_ALLOW_THREADS;
+
+	if (res != 0) {
+		PyErr_SetObject(PyKCOIDCError, PyLong_FromLong(res));
+		return NULL;
+	}
+
+	return PyLong_FromLong(res);
+}
+
+static PyObject *
+pykcoidc_set_claims_profile(PyObject *self, PyObject *args)
+{
+	char *name_s;
+	int res;
+
+	if (!PyArg_ParseTuple(args, "s", &name_s))
+		return NULL;
+
+	Py_BEGIN_ALLOW_THREADS;
+	res = kcoidc_set_claims_profile(name_s);
+	Py_END_ALLOW_THREADS;
+
+	if (res != 0) {
+		PyErr_SetObject(PyKCOIDCError, PyLong_FromLong(res));
+		return NULL;
+	}
+
+	return PyLong_FromLong(res);
+}
+
+static PyObject *
+pykcoidc_set_user_id_claim_paths(PyObject *self, PyObject *args)
+{
+	char *claim_paths_s;
+	int res;
+
+	if (!PyArg_ParseTuple(args, "s", &claim_paths_s))
+		return NULL;
+
+	Py_BEGIN_ALLOW_THREADS;
+	res = kcoidc_set_user_id_claim_paths(claim_paths_s);
+	Py_END_ALLOW_THREADS;
+
+	if (res != 0) {
+		PyErr_SetObject(PyKCOIDCError, PyLong_FromLong(res));
+		return NULL;
+	}
+
+	return PyLong_FromLong(res);
+}
+
+static PyObject *
+pykcoidc_set_role_claim_paths(PyObject *self, PyObject *args)
+{
+	char *claim_paths_s;
+	int res;
+
+	if (!PyArg_ParseTuple(args, "s", &claim_paths_s))
+		return NULL;
+
+	Py_BEGIN_ALLOW_THREADS;
+	res = kcoidc_set_role_claim_paths(claim_paths_s);
+	Py_END_ALLOW_THREADS;
+
+	if (res != 0) {
+		PyErr_SetObject(PyKCOIDCError, PyLong_FromLong(res));
+		return NULL;
+	}
+
+	return PyLong_FromLong(res);
+}
+
+static PyObject *
+pykcoidc_set_policy_file(PyObject *self, PyObject *args)
+{
+	char *fn_s;
+	int res;
+
+	if (!PyArg_ParseTuple(args, "s", &fn_s))
+		return NULL;
+
+	Py_BEGIN_ALLOW_THREADS;
+	res = kcoidc_set_policy_file(fn_s);
+	Py_END_ALLOW_THREADS;
+
+	if (res != 0) {
+		PyErr_SetObject(PyKCOIDCError, PyLong_FromLong(res));
+		return NULL;
+	}
+
+	return PyLong_FromLong(res);
+}
+
+static PyObject *
+pykcoidc_set_guest_policy(PyObject *self, PyObject *args)
+{
+	int guest_policy;
+	char *scopes_s = "";
+	int res;
+
+	if (!PyArg_ParseTuple(args, "i|s", &guest_policy, &scopes_s))
+		return NULL;
+
+	Py_BEGIN_ALLOW_THREADS;
+	res = kcoidc_set_guest_policy(guest_policy, scopes_s);
+	Py_END_ALLOW_THREADS;
+
+	if (res != 0) {
+		PyErr_SetObject(PyKCOIDCError, PyLong_FromLong(res));
+		return NULL;
+	}
+
+	return PyLong_FromLong(res);
+}
+
+static PyObject *
+pykcoidc_set_signing_algs(PyObject *self, PyObject *args)
+{
+	char *id_token_algs_s;
+	char *access_token_algs_s;
+	int override;
+	int res;
+
+	if (!PyArg_ParseTuple(args, "ssi", &id_token_algs_s, &access_token_algs_s, &override))
+		return NULL;
+
+	Py_BEGIN_ALLOW_THREADS;
+	res = kcoidc_set_signing_algs(id_token_algs_s, access_token_algs_s, override);
+	Py_END_ALLOW_THREADS;
+
+	if (res != 0) {
+		PyErr_SetObject(PyKCOIDCError, PyLong_FromLong(res));
+		return NULL;
+	}
+
+	return PyLong_FromLong(res);
+}
+
+static PyObject *
+pykcoidc_set_key_policy(PyObject *self, PyObject *args)
+{
+	int min_rsa_key_size;
+	char *allowed_curves_s = "";
+	char *allowed_key_types_s = "";
+	int res;
+
+	if (!PyArg_ParseTuple(args, "i|ss", &min_rsa_key_size, &allowed_curves_s, &allowed_key_types_s))
+		return NULL;
+
+	Py_BEGIN_ALLOW_THREADS;
+	res = kcoidc_set_key_policy(min_rsa_key_size, allowed_curves_s, allowed_key_types_s);
+	Py_END_ALLOW_THREADS;
+
+	if (res != 0) {
+		PyErr_SetObject(PyKCOIDCError, PyLong_FromLong(res));
+		return NULL;
+	}
+
+	return PyLong_FromLong(res);
+}
+
+static PyObject *
+pykcoidc_set_key_ca_file(PyObject *self, PyObject *args)
+{
+	char *fn_s;
+	int res;
+
+	if (!PyArg_ParseTuple(args, "s", &fn_s))
+		return NULL;
+
+	Py_BEGIN_ALLOW_THREADS;
+	res = kcoidc_set_key_ca_file(fn_s);
+	Py_END_ALLOW_THREADS;
+
+	if (res != 0) {
+		PyErr_SetObject(PyKCOIDCError, PyLong_FromLong(res));
+		return NULL;
+	}
+
+	return PyLong_FromLong(res);
+}
+
+static PyObject *
+pykcoidc_set_extra_keys_file(PyObject *self, PyObject *args)
+{
+	char *fn_s;
+	int res;
+
+	if (!PyArg_ParseTuple(args, "s", &fn_s))
+		return NULL;
+
+	Py_BEGIN_ALLOW_THREADS;
+	res = kcoidc_set_extra_keys_file(fn_s);
+	Py_END_ALLOW_THREADS;
+
+	if (res != 0) {
+		PyErr_SetObject(PyKCOIDCError, PyLong_FromLong(res));
+		return NULL;
+	}
+
+	return PyLong_FromLong(res);
+}
+
+static PyObject *
+pykcoidc_set_pinned_keys(PyObject *self, PyObject *args)
+{
+	char *thumbprints_s;
+	int res;
+
+	if (!PyArg_ParseTuple(args, "s", &thumbprints_s))
+		return NULL;
+
+	Py_BEGIN_ALLOW_THREADS;
+	res = kcoidc_set_pinned_keys(thumbprints_s);
+	Py_END_ALLOW_THREADS;
+
+	if (res != 0) {
+		PyErr_SetObject(PyKCOIDCError, PyLong_FromLong(res));
+		return NULL;
+	}
+
+	return PyLong_FromLong(res);
+}
+
 static PyObject *
-pykcoidc_initialize(PyObject *self, PyObject *args)
+pykcoidc_set_key_grace_period(PyObject *self, PyObject *args)
 {
-	char *iss_s;
+	unsigned long long grace_period;
+	unsigned long long max_token_lifetime;
 	int res;
 
-	if (!PyArg_ParseTuple(args, "s", &iss_s))
+	if (!PyArg_ParseTuple(args, "KK", &grace_period, &max_token_lifetime))
 		return NULL;
 
 	Py_BEGIN_ALLOW_THREADS;
-	res = kcoidc_initialize(iss_s);
+	res = kcoidc_set_key_grace_period(grace_period, max_token_lifetime);
 	Py_END_ALLOW_THREADS;
 
 	if (res != 0) {
@@ -38,16 +434,17 @@ pykcoidc_initialize(PyObject *self, PyObject *args)
 }
 
 static PyObject *
-pykcoidc_wait_until_ready(PyObject *self, PyObject *args)
+pykcoidc_set_staleness_policy(PyObject *self, PyObject *args)
 {
-	unsigned long long timeout;
+	unsigned long long max_staleness;
+	int staleness_policy;
 	int res;
 
-	if (!PyArg_ParseTuple(args, "K", &timeout))
+	if (!PyArg_ParseTuple(args, "Ki", &max_staleness, &staleness_policy))
 		return NULL;
 
 	Py_BEGIN_ALLOW_THREADS;
-	res = kcoidc_wait_until_ready(timeout);
+	res = kcoidc_set_staleness_policy(max_staleness, staleness_policy);
 	Py_END_ALLOW_THREADS;
 
 	if (res != 0) {
@@ -59,16 +456,19 @@ pykcoidc_wait_until_ready(PyObject *self, PyObject *args)
 }
 
 static PyObject *
-pykcoidc_insecure_skip_verify(PyObject *self, PyObject *args)
+pykcoidc_set_refresh_policy(PyObject *self, PyObject *args)
 {
-	int insecure;
+	unsigned long long interval;
+	unsigned long long jitter;
+	unsigned long long min_backoff;
+	unsigned long long max_backoff;
 	int res;
 
-	if (!PyArg_ParseTuple(args, "i", &insecure))
+	if (!PyArg_ParseTuple(args, "KKKK", &interval, &jitter, &min_backoff, &max_backoff))
 		return NULL;
 
 	Py_BEGIN_ALLOW_THREADS;
-	res = kcoidc_insecure_skip_verify(insecure);
+	res = kcoidc_set_refresh_policy(interval, jitter, min_backoff, max_backoff);
 	Py_END_ALLOW_THREADS;
 
 	if (res != 0) {
@@ -80,32 +480,98 @@ pykcoidc_insecure_skip_verify(PyObject *self, PyObject *args)
 }
 
 static PyObject *
-pykcoidc_validate_token_s(PyObject *self, PyObject *args)
+pykcoidc_set_initialize_retry(PyObject *self, PyObject *args)
+{
+	int retry;
+	unsigned long long min_backoff;
+	unsigned long long max_backoff;
+	int res;
+
+	if (!PyArg_ParseTuple(args, "iKK", &retry, &min_backoff, &max_backoff))
+		return NULL;
+
+	Py_BEGIN_ALLOW_THREADS;
+	res = kcoidc_set_initialize_retry(retry, min_backoff, max_backoff);
+	Py_END_ALLOW_THREADS;
+
+	if (res != 0) {
+		PyErr_SetObject(PyKCOIDCError, PyLong_FromLong(res));
+		return NULL;
+	}
+
+	return PyLong_FromLong(res);
+}
+
+static PyObject *
+pykcoidc_set_decryption_key_file(PyObject *self, PyObject *args)
+{
+	char *fn_s;
+	int res;
+
+	if (!PyArg_ParseTuple(args, "s", &fn_s))
+		return NULL;
+
+	Py_BEGIN_ALLOW_THREADS;
+	res = kcoidc_set_decryption_key_file(fn_s);
+	Py_END_ALLOW_THREADS;
+
+	if (res != 0) {
+		PyErr_SetObject(PyKCOIDCError, PyLong_FromLong(res));
+		return NULL;
+	}
+
+	return PyLong_FromLong(res);
+}
+
+static PyObject *
+pykcoidc_set_token_limits(PyObject *self, PyObject *args)
+{
+	int max_length;
+	int max_claims_depth;
+	int res;
+
+	if (!PyArg_ParseTuple(args, "ii", &max_length, &max_claims_depth))
+		return NULL;
+
+	Py_BEGIN_ALLOW_THREADS;
+	res = kcoidc_set_token_limits(max_length, max_claims_depth);
+	Py_END_ALLOW_THREADS;
+
+	if (res != 0) {
+		PyErr_SetObject(PyKCOIDCError, PyLong_FromLong(res));
+		return NULL;
+	}
+
+	return PyLong_FromLong(res);
+}
+
+static PyObject *
+pykcoidc_validate_token_ex_s(PyObject *self, PyObject *args)
 {
 	PyObject *res = NULL;
 	char *token_s;
-	struct kcoidc_validate_token_s_return token_result;
+	int allowed_token_types_mask;
+	struct kcoidc_validate_token_ex_s_return token_result;
 
-	if (!PyArg_ParseTuple(args, "s", &token_s))
+	if (!PyArg_ParseTuple(args, "si", &token_s, &allowed_token_types_mask))
 		return NULL;
 
 	Py_BEGIN_ALLOW_THREADS;
-	token_result = kcoidc_validate_token_s(token_s);
+	token_result = kcoidc_validate_token_ex_s(token_s, allowed_token_types_mask);
 	Py_END_ALLOW_THREADS;
 
 	if (token_result.r1 != 0) {
 		PyErr_SetObject(PyKCOIDCError, PyLong_FromLong(token_result.r1));
 	} else {
-		res = Py_BuildValue("zizz", token_result.r0, token_result.r2, token_result.r3, token_result.r4);
+		res = Py_BuildValue("z", token_result.r0);
 	}
 
 	// Free the strings passed from the library.
 	free(token_result.r0);
-	free(token_result.r3);
-	free(token_result.r4);
 
 	return res;
 }
+#endif
 
 #ifdef WITH_REQUIRE_SCOPE
 static PyObject *
@@ -138,6 +604,167 @@ pykcoidc_validate_token_and_require_scope_s(PyObject *self, PyObject *args)
 }
 #endif
 
+#ifdef WITH_REQUIRE_SCOPES
+static PyObject *
+pykcoidc_validate_token_and_require_scopes_s(PyObject *self, PyObject *args)
+{
+	PyObject *res = NULL;
+	char *token_s;
+	char *required_scopes_s;
+	struct kcoidc_validate_token_and_require_scopes_s_return token_result;
+
+	if (!PyArg_ParseTuple(args, "ss", &token_s, &required_scopes_s))
+		return NULL;
+
+	Py_BEGIN_ALLOW_THREADS;
+	token_result = kcoidc_validate_token_and_require_scopes_s(token_s, required_scopes_s);
+	Py_END_ALLOW_THREADS;
+
+	if (token_result.r1 != 0) {
+		// Raise with the status code and the list of missing scopes.
+		pykcoidc_set_error_with_fields(token_result.r1, token_result.r5);
+	} else {
+		res = Py_BuildValue("zizzO", token_result.r0, token_result.r2, token_result.r3, token_result.r4, token_result.r6 ? Py_True : Py_False);
+	}
+
+	// Free the strings passed from the library.
+	free(token_result.r0);
+	free(token_result.r3);
+	free(token_result.r4);
+	free(token_result.r5);
+
+	return res;
+}
+#endif
+
+#ifdef WITH_REQUIRE_AUTHORIZED_CLAIMS
+static PyObject *
+pykcoidc_validate_token_and_require_authorized_claims_s(PyObject *self, PyObject *args)
+{
+	PyObject *res = NULL;
+	char *token_s;
+	char *claims_request_s;
+	struct kcoidc_validate_token_and_require_authorized_claims_s_return token_result;
+
+	if (!PyArg_ParseTuple(args, "ss", &token_s, &claims_request_s))
+		return NULL;
+
+	Py_BEGIN_ALLOW_THREADS;
+	token_result = kcoidc_validate_token_and_require_authorized_claims_s(token_s, claims_request_s);
+	Py_END_ALLOW_THREADS;
+
+	if (token_result.r1 != 0) {
+		// Raise with the status code and the list of missing claims.
+		pykcoidc_set_error_with_fields(token_result.r1, token_result.r5);
+	} else {
+		res = Py_BuildValue("zizzO", token_result.r0, token_result.r2, token_result.r3, token_result.r4, token_result.r6 ? Py_True : Py_False);
+	}
+
+	// Free the strings passed from the library.
+	free(token_result.r0);
+	free(token_result.r3);
+	free(token_result.r4);
+	free(token_result.r5);
+
+	return res;
+}
+#endif
+
+#ifdef WITH_REQUIRE_ROLE
+static PyObject *
+pykcoidc_validate_token_and_require_role_s(PyObject *self, PyObject *args)
+{
+	PyObject *res = NULL;
+	char *token_s;
+	char *required_role_s;
+	struct kcoidc_validate_token_and_require_role_s_return token_result;
+
+	if (!PyArg_ParseTuple(args, "ss", &token_s, &required_role_s))
+		return NULL;
+
+	Py_BEGIN_ALLOW_THREADS;
+	token_result = kcoidc_validate_token_and_require_role_s(token_s, required_role_s);
+	Py_END_ALLOW_THREADS;
+
+	if (token_result.r1 != 0) {
+		PyErr_SetObject(PyKCOIDCError, PyLong_FromLong(token_result.r1));
+	} else {
+		res = Py_BuildValue("zizzO", token_result.r0, token_result.r2, token_result.r3, token_result.r4, token_result.r5 ? Py_True : Py_False);
+	}
+
+	// Free the strings passed from the library.
+	free(token_result.r0);
+	free(token_result.r3);
+	free(token_result.r4);
+
+	return res;
+}
+#endif
+
+#ifdef WITH_DPOP
+static PyObject *
+pykcoidc_validate_dpop_token_s(PyObject *self, PyObject *args)
+{
+	PyObject *res = NULL;
+	char *token_s;
+	char *proof_s;
+	char *method_s;
+	char *uri_s;
+	struct kcoidc_validate_dpop_token_s_return token_result;
+
+	if (!PyArg_ParseTuple(args, "ssss", &token_s, &proof_s, &method_s, &uri_s))
+		return NULL;
+
+	Py_BEGIN_ALLOW_THREADS;
+	token_result = kcoidc_validate_dpop_token_s(token_s, proof_s, method_s, uri_s);
+	Py_END_ALLOW_THREADS;
+
+	if (token_result.r1 != 0) {
+		PyErr_SetObject(PyKCOIDCError, PyLong_FromLong(token_result.r1));
+	} else {
+		res = Py_BuildValue("zizzO", token_result.r0, token_result.r2, token_result.r3, token_result.r4, token_result.r5 ? Py_True : Py_False);
+	}
+
+	// Free the strings passed from the library.
+	free(token_result.r0);
+	free(token_result.r3);
+	free(token_result.r4);
+
+	return res;
+}
+#endif
+
+#ifdef WITH_MTLS
+static PyObject *
+pykcoidc_validate_certificate_bound_token_s(PyObject *self, PyObject *args)
+{
+	PyObject *res = NULL;
+	char *token_s;
+	char *cert_pem_s;
+	struct kcoidc_validate_certificate_bound_token_s_return token_result;
+
+	if (!PyArg_ParseTuple(args, "ss", &token_s, &cert_pem_s))
+		return NULL;
+
+	Py_BEGIN_ALLOW_THREADS;
+	token_result = kcoidc_validate_certificate_bound_token_s(token_s, cert_pem_s);
+	Py_END_ALLOW_THREADS;
+
+	if (token_result.r1 != 0) {
+		PyErr_SetObject(PyKCOIDCError, PyLong_FromLong(token_result.r1));
+	} else {
+		res = Py_BuildValue("zizzO", token_result.r0, token_result.r2, token_result.r3, token_result.r4, token_result.r5 ? Py_True : Py_False);
+	}
+
+	// Free the strings passed from the library.
+	free(token_result.r0);
+	free(token_result.r3);
+	free(token_result.r4);
+
+	return res;
+}
+#endif
+
 static PyObject *
 pykcoidc_fetch_userinfo_with_accesstoken_s(PyObject *self, PyObject *args)
 {
@@ -164,6 +791,88 @@ pykcoidc_fetch_userinfo_with_accesstoken_s(PyObject *self, PyObject *args)
 	return res;
 }
 
+#ifdef WITH_KONNECT_IDENTITY
+static PyObject *
+pykcoidc_konnect_identity_from_claims_s(PyObject *self, PyObject *args)
+{
+	PyObject *res = NULL;
+	char *extra_claims_s;
+	struct kcoidc_konnect_identity_from_claims_s_return identity_result;
+
+	if (!PyArg_ParseTuple(args, "s", &extra_claims_s))
+		return NULL;
+
+	Py_BEGIN_ALLOW_THREADS;
+	identity_result = kcoidc_konnect_identity_from_claims_s(extra_claims_s);
+	Py_END_ALLOW_THREADS;
+
+	if (identity_result.r1 != 0) {
+		PyErr_SetObject(PyKCOIDCError, PyLong_FromLong(identity_result.r1));
+	} else {
+		res = Py_BuildValue("z", identity_result.r0);
+	}
+
+	// Free the strings passed from the library.
+	free(identity_result.r0);
+
+	return res;
+}
+#endif
+
+#ifdef WITH_STATUS
+static PyObject *
+pykcoidc_status_s(PyObject *self, PyObject *args)
+{
+	PyObject *res = NULL;
+	struct kcoidc_status_s_return status_result;
+
+	if (!PyArg_ParseTuple(args, ""))
+		return NULL;
+
+	Py_BEGIN_ALLOW_THREADS;
+	status_result = kcoidc_status_s();
+	Py_END_ALLOW_THREADS;
+
+	if (status_result.r1 != 0) {
+		PyErr_SetObject(PyKCOIDCError, PyLong_FromLong(status_result.r1));
+	} else {
+		res = Py_BuildValue("z", status_result.r0);
+	}
+
+	// Free the strings passed from the library.
+	free(status_result.r0);
+
+	return res;
+}
+#endif
+
+#ifdef WITH_REFRESH
+static PyObject *
+pykcoidc_refresh(PyObject *self, PyObject *args)
+{
+	PyObject *res = NULL;
+	struct kcoidc_refresh_return refresh_result;
+
+	if (!PyArg_ParseTuple(args, ""))
+		return NULL;
+
+	Py_BEGIN_ALLOW_THREADS;
+	refresh_result = kcoidc_refresh();
+	Py_END_ALLOW_THREADS;
+
+	if (refresh_result.r1 != 0) {
+		PyErr_SetObject(PyKCOIDCError, PyLong_FromLong(refresh_result.r1));
+	} else {
+		res = Py_BuildValue("z", refresh_result.r0);
+	}
+
+	// Free the strings passed from the library.
+	free(refresh_result.r0);
+
+	return res;
+}
+#endif
+
 static PyObject *
 pykcoidc_uninitialize(PyObject *self, PyObject *args)
 {
@@ -189,10 +898,55 @@ static PyMethodDef MyMethods[] = {
 	{"wait_until_ready", pykcoidc_wait_until_ready, METH_VARARGS, "Wait until ODIC is ready or until timeout."},
 	{"insecure_skip_verify", pykcoidc_insecure_skip_verify, METH_VARARGS, "Set insecure skip verify flag."},
 	{"validate_token_s", pykcoidc_validate_token_s, METH_VARARGS, "Validate token and return authenticted user ID."},
+#ifdef WITH_TOKEN_TYPES
+	{"set_allowed_token_types", pykcoidc_set_allowed_token_types, METH_VARARGS, "Set allowed token types mask."},
+	{"set_require_jwt_access_token_type", pykcoidc_set_require_jwt_access_token_type, METH_VARARGS, "Set require JWT access token type flag."},
+	{"set_claims_profile", pykcoidc_set_claims_profile, METH_VARARGS, "Set claims profile by name."},
+	{"set_user_id_claim_paths", pykcoidc_set_user_id_claim_paths, METH_VARARGS, "Set space separated user ID claim paths."},
+	{"set_role_claim_paths", pykcoidc_set_role_claim_paths, METH_VARARGS, "Set space separated role claim paths."},
+	{"set_policy_file", pykcoidc_set_policy_file, METH_VARARGS, "Load claims policy from JSON file."},
+	{"set_guest_policy", pykcoidc_set_guest_policy, METH_VARARGS, "Set guest policy and space separated allowed guest scopes."},
+	{"set_signing_algs", pykcoidc_set_signing_algs, METH_VARARGS, "Set space separated ID token and access token signing algorithms and override flag."},
+	{"set_key_policy", pykcoidc_set_key_policy, METH_VARARGS, "Set key policy with minimum RSA key size and space separated allowed curves and key types."},
+	{"set_key_ca_file", pykcoidc_set_key_ca_file, METH_VARARGS, "Load PEM CA certificates to validate x5c chains of keys against."},
+	{"set_extra_keys_file", pykcoidc_set_extra_keys_file, METH_VARARGS, "Load additional trusted public keys from PEM or JWK set file."},
+	{"set_pinned_keys", pykcoidc_set_pinned_keys, METH_VARARGS, "Set space separated JWK thumbprints of the only accepted keys."},
+	{"set_key_grace_period", pykcoidc_set_key_grace_period, METH_VARARGS, "Set seconds removed keys stay accepted and max token lifetime in seconds."},
+	{"set_staleness_policy", pykcoidc_set_staleness_policy, METH_VARARGS, "Set max staleness in seconds and staleness policy."},
+	{"set_refresh_policy", pykcoidc_set_refresh_policy, METH_VARARGS, "Set refresh interval, jitter, min and max backoff in seconds."},
+	{"set_initialize_retry", pykcoidc_set_initialize_retry, METH_VARARGS, "Set initialize retry flag with min and max backoff in seconds."},
+	{"set_decryption_key_file", pykcoidc_set_decryption_key_file, METH_VARARGS, "Load private decryption keys from PEM or JWK set file."},
+	{"set_token_limits", pykcoidc_set_token_limits, METH_VARARGS, "Set maximum token length and maximum claims nesting depth, zero for defaults."},
+	{"validate_token_ex_s", pykcoidc_validate_token_ex_s, METH_VARARGS, "Validate token with allowed token types mask and return the result as JSON."},
+#endif
 #ifdef WITH_REQUIRE_SCOPE
 	{"validate_token_and_require_scope_s", pykcoidc_validate_token_and_require_scope_s, METH_VARARGS, "Validate token and scope and return authenticated user ID."},
+#endif
+#ifdef WITH_REQUIRE_SCOPES
+	{"validate_token_and_require_scopes_s", pykcoidc_validate_token_and_require_scopes_s, METH_VARARGS, "Validate token and space separated scopes and return authenticated user ID. Errors hold the status code and the missing scopes."},
+#endif
+#ifdef WITH_REQUIRE_AUTHORIZED_CLAIMS
+	{"validate_token_and_require_authorized_claims_s", pykcoidc_validate_token_and_require_authorized_claims_s, METH_VARARGS, "Validate token and JSON claims request and return authenticated user ID. Errors hold the status code and the missing claims."},
+#endif
+#ifdef WITH_REQUIRE_ROLE
+	{"validate_token_and_require_role_s", pykcoidc_validate_token_and_require_role_s, METH_VARARGS, "Validate token and role and return authenticated user ID."},
+#endif
+#ifdef WITH_DPOP
+	{"validate_dpop_token_s", pykcoidc_validate_dpop_token_s, METH_VARARGS, "Validate DPoP bound token with proof, HTTP method and URI and return authenticated user ID."},
+#endif
+#ifdef WITH_MTLS
+	{"validate_certificate_bound_token_s", pykcoidc_validate_certificate_bound_token_s, METH_VARARGS, "Validate certificate bound token with PEM client certificate and return authenticated user ID."},
 #endif
 	{"fetch_userinfo_with_accesstoken_s", pykcoidc_fetch_userinfo_with_accesstoken_s, METH_VARARGS, "Fetch userinfo with access token."},
+#ifdef WITH_STATUS
+	{"status_s", pykcoidc_status_s, METH_VARARGS, "Return provider status as JSON."},
+#endif
+#ifdef WITH_KONNECT_IDENTITY
+	{"konnect_identity_from_claims_s", pykcoidc_konnect_identity_from_claims_s, METH_VARARGS, "Decode Konnect identity from JSON extra claims."},
+#endif
+#ifdef WITH_REFRESH
+	{"refresh", pykcoidc_refresh, METH_VARARGS, "Refresh provider definition and return changes as JSON."},
+#endif
 	{"uninitialize",  pykcoidc_uninitialize, METH_VARARGS, "Uninitialize ODIC."},
 	{NULL, NULL, 0, NULL} /* Sentinel */
 };
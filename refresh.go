@@ -0,0 +1,271 @@
+/*
+ * SPDX-License-Identifier: AGPL-3.0-or-later
+ * Copyright 2020 Kopano and its licensors
+ */
+
+package kcoidc
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net/url"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/openkop/oidc-go"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// A RefreshPolicy defines how often a Provider refreshes its discovery
+// document and JWKS. Without a RefreshPolicy, refreshes are scheduled when the
+// fetched documents expire according to their HTTP caching headers.
+type RefreshPolicy struct {
+	// Interval is the time between refreshes. If zero, refreshes are scheduled
+	// based on HTTP caching as without a RefreshPolicy.
+	Interval time.Duration
+	// Jitter is the maximum random duration which is added to or subtracted
+	// from Interval, to spread out refreshes of many instances.
+	Jitter time.Duration
+	// MinBackoff and MaxBackoff bound the exponential backoff between retries
+	// of failed refreshes. If zero, DefaultRefreshMinBackoff and Interval or
+	// DefaultRefreshInterval are used respectively.
+	MinBackoff time.Duration
+	MaxBackoff time.Duration
+}
+
+// DefaultRefreshMinBackoff is the initial backoff after a failed refresh if
+// not explicitly configured otherwise.
+var DefaultRefreshMinBackoff = 5 * time.Second
+
+// DefaultRefreshInterval is the time between refreshes if neither a
+// RefreshPolicy nor the HTTP caching headers of the fetched documents define
+// it.
+var DefaultRefreshInterval = time.Minute
+
+// A RefreshResult describes what changed with a refresh.
+type RefreshResult struct {
+	Changed          bool     `json:"changed"`
+	DiscoveryChanged bool     `json:"discovery_changed"`
+	KeysAdded        []string `json:"keys_added"`
+	KeysRemoved      []string `json:"keys_removed"`
+}
+
+// delay returns the time to wait before the next refresh after the provided
+// number of consecutive failed refreshes. Without an Interval, the refresh is
+// due when the provided expiry of the current definition is reached. The
+// policy can be nil to use the defaults.
+func (policy *RefreshPolicy) delay(failures int, expires time.Time) time.Duration {
+	var interval, jitter, minBackoff, maxBackoff time.Duration
+	if policy != nil {
+		interval, jitter = policy.Interval, policy.Jitter
+		minBackoff, maxBackoff = policy.MinBackoff, policy.MaxBackoff
+	}
+
+	if failures > 0 {
+		if minBackoff <= 0 {
+			minBackoff = DefaultRefreshMinBackoff
+		}
+		if maxBackoff <= 0 {
+			maxBackoff = interval
+		}
+		if maxBackoff <= 0 {
+			maxBackoff = DefaultRefreshInterval
+		}
+		return backoffDelay(failures, minBackoff, maxBackoff)
+	}
+
+	if interval <= 0 {
+		// Do not refresh more often than after failures when the documents
+		// cannot be cached or expire immediately.
+		if delay := time.Until(expires); delay >= DefaultRefreshMinBackoff {
+			return delay
+		}
+		return DefaultRefreshInterval
+	}
+
+	delay := interval
+	if jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(2*jitter))) - jitter
+	}
+	if delay <= 0 {
+		delay = interval
+	}
+	return delay
+}
+
+// SetRefreshPolicy sets the RefreshPolicy of the associated Provider. If nil
+// is provided, refreshes are scheduled based on HTTP caching. Invalid policies
+// are rejected with errors wrapping ErrStatusInvalidRefreshPolicy. It must be
+// called before the call to initialize.
+func (p *Provider) SetRefreshPolicy(policy *RefreshPolicy) error {
+	if policy != nil {
+		if policy.Interval < 0 || policy.Jitter < 0 || policy.MinBackoff < 0 || policy.MaxBackoff < 0 {
+			return fmt.Errorf("%w: negative duration", ErrStatusInvalidRefreshPolicy)
+		}
+		if policy.Jitter > 0 && policy.Jitter >= policy.Interval {
+			return fmt.Errorf("%w: jitter must be less than interval", ErrStatusInvalidRefreshPolicy)
+		}
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.initialized {
+		return ErrStatusAlreadyInitialized
+	}
+	p.refreshPolicy = policy
+
+	return nil
+}
+
+// Refresh synchronously fetches the discovery document and JWKS of the
+// associated Provider and applies them. The returned RefreshResult reports
+// what changed. If fetching fails, the current definition is kept and the
+// failure is recorded like failed background refreshes.
+func (p *Provider) Refresh(ctx context.Context) (*RefreshResult, error) {
+	result, _, err := p.refresh(ctx)
+	return result, err
+}
+
+// refresh implements Refresh and additionally returns the expiry of the
+// fetched definition.
+func (p *Provider) refresh(ctx context.Context) (*RefreshResult, time.Time, error) {
+	p.mutex.RLock()
+	initialized := p.initialized
+	issuer := p.issuer
+	p.mutex.RUnlock()
+	if !initialized {
+		return nil, time.Time{}, ErrStatusNotInitialized
+	}
+
+	definition, expires, err := p.fetchDefinition(ctx, issuer)
+
+	now := time.Now()
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if !p.initialized {
+		return nil, time.Time{}, ErrStatusNotInitialized
+	}
+	if err != nil {
+		p.refreshFailed(err, now)
+		return nil, time.Time{}, fmt.Errorf("%w: %v", ErrStatusRefreshFailed, err)
+	}
+
+	result := diffDefinitions(p.definition, definition)
+	if result.Changed {
+		p.applyDefinition(definition, now)
+	} else {
+		p.refreshSucceeded(now)
+	}
+
+	return result, expires, nil
+}
+
+// fetchDefinition fetches the discovery document and the JWKS of the provided
+// issuer the same way as oidc-go does. It also returns when the first of them
+// expires according to HTTP caching. Returned errors wrap the ErrStatus
+// describing the kind of failure.
+func (p *Provider) fetchDefinition(ctx context.Context, issuer *url.URL) (*oidc.ProviderDefinition, time.Time, error) {
+	relativeWellKnownURI, _ := url.Parse("/.well-known/openid-configuration")
+	wellKnownURI := issuer.ResolveReference(relativeWellKnownURI)
+
+	wellKnown := &oidc.WellKnown{}
+	expires, err := fetchJSON(ctx, p.httpClient, wellKnownURI.String(), nil, nil, true, wellKnown)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("%w: failed to fetch discover document: %v", fetchErrorStatus(err), err)
+	}
+	if wellKnown.Issuer != issuer.String() {
+		return nil, time.Time{}, fmt.Errorf("%w: issuer mismatch: %v != %v", ErrStatusInvalidIss, wellKnown.Issuer, issuer.String())
+	}
+	if wellKnown.JwksURI == "" {
+		return nil, time.Time{}, fmt.Errorf("%w: discover document without jwks_uri", ErrStatusInvalidProviderResponse)
+	}
+
+	jwks := &jose.JSONWebKeySet{}
+	jwksExpires, err := fetchJSON(ctx, p.httpClient, wellKnown.JwksURI, nil, nil, true, jwks)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("%w: failed to fetch jwks: %v", fetchErrorStatus(err), err)
+	}
+	if jwksExpires.Before(expires) {
+		expires = jwksExpires
+	}
+
+	definition := &oidc.ProviderDefinition{
+		WellKnown: wellKnown,
+		JWKS:      jwks,
+	}
+	p.mutex.RLock()
+	extraKeys := p.extraKeys
+	p.mutex.RUnlock()
+	if err = checkDefinition(definition, extraKeys); err != nil {
+		return nil, time.Time{}, fmt.Errorf("%w: %v", ErrStatusInvalidProviderResponse, err)
+	}
+
+	return definition, expires, nil
+}
+
+// diffDefinitions returns what changed from the provided previous to the
+// provided current definition. Keys are identified by key ID and thumbprint.
+func diffDefinitions(previous *oidc.ProviderDefinition, current *oidc.ProviderDefinition) *RefreshResult {
+	result := &RefreshResult{
+		KeysAdded:   make([]string, 0),
+		KeysRemoved: make([]string, 0),
+	}
+	if previous == nil {
+		previous = emptyProviderDefintion
+	}
+
+	result.DiscoveryChanged = !reflect.DeepEqual(previous.WellKnown, current.WellKnown)
+
+	keyIDs := func(jwks *jose.JSONWebKeySet) map[string]string {
+		ids := make(map[string]string)
+		if jwks != nil {
+			for idx := range jwks.Keys {
+				ids[jwks.Keys[idx].KeyID+"/"+keyThumbprint(&jwks.Keys[idx])] = jwks.Keys[idx].KeyID
+			}
+		}
+		return ids
+	}
+	previousKeys, currentKeys := keyIDs(previous.JWKS), keyIDs(current.JWKS)
+	for id, kid := range currentKeys {
+		if _, ok := previousKeys[id]; !ok {
+			result.KeysAdded = append(result.KeysAdded, kid)
+		}
+	}
+	for id, kid := range previousKeys {
+		if _, ok := currentKeys[id]; !ok {
+			result.KeysRemoved = append(result.KeysRemoved, kid)
+		}
+	}
+	sort.Strings(result.KeysAdded)
+	sort.Strings(result.KeysRemoved)
+
+	result.Changed = result.DiscoveryChanged || len(result.KeysAdded) > 0 || len(result.KeysRemoved) > 0
+
+	return result
+}
+
+// refreshLoop refreshes the associated Provider with the provided policy
+// until the provided context is done, starting from a definition which expires
+// at the provided time. This is the only place where the definition is
+// refreshed in the background.
+func (p *Provider) refreshLoop(ctx context.Context, policy *RefreshPolicy, expires time.Time) {
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(policy.delay(failures, expires)):
+		}
+
+		_, refreshExpires, err := p.refresh(ctx)
+		if err != nil {
+			failures++
+		} else {
+			failures = 0
+			expires = refreshExpires
+		}
+	}
+}
@@ -0,0 +1,77 @@
+/*
+ * SPDX-License-Identifier: AGPL-3.0-or-later
+ * Copyright 2020 Kopano and its licensors
+ */
+
+package kcoidc
+
+// DefaultRoleClaimPaths defines the claim paths where roles and groups are
+// looked up if not explicitly configured otherwise.
+var DefaultRoleClaimPaths = []string{"roles", "groups", "realm_access.roles"}
+
+// Roles returns the roles and groups as bool map found at the provided dot
+// separated claim paths of the accociated claims. Claim values can either be
+// a single string or an array of strings. If no claim paths are provided, the
+// DefaultRoleClaimPaths are used.
+func (claims *ExtraClaimsWithType) Roles(claimPaths ...string) map[string]bool {
+	if len(claimPaths) == 0 {
+		claimPaths = DefaultRoleClaimPaths
+	}
+
+	roles := make(map[string]bool)
+	for _, claimPath := range claimPaths {
+		value, ok := valueFromMapPath(*claims, claimPath)
+		if !ok {
+			continue
+		}
+		switch vt := value.(type) {
+		case string:
+			if vt != "" {
+				roles[vt] = true
+			}
+		case []interface{}:
+			for _, v := range vt {
+				if role, _ := v.(string); role != "" {
+					roles[role] = true
+				}
+			}
+		}
+	}
+
+	return roles
+}
+
+// RequireRolesInClaims returns nil if all the provided roles are found at the
+// provided claim paths of the provided claims. Otherwise an error is returned.
+func RequireRolesInClaims(claims *ExtraClaimsWithType, requiredRoles []string, claimPaths ...string) error {
+	if len(requiredRoles) == 0 {
+		return nil
+	}
+
+	roles := claims.Roles(claimPaths...)
+	for _, role := range requiredRoles {
+		if ok, _ := roles[role]; !ok {
+			return ErrStatusMissingRequiredRole
+		}
+	}
+
+	return nil
+}
+
+// RequireAnyRoleInClaims returns nil if any of the provided roles is found at
+// the provided claim paths of the provided claims. Otherwise an error is
+// returned.
+func RequireAnyRoleInClaims(claims *ExtraClaimsWithType, anyRoles []string, claimPaths ...string) error {
+	if len(anyRoles) == 0 {
+		return nil
+	}
+
+	roles := claims.Roles(claimPaths...)
+	for _, role := range anyRoles {
+		if ok, _ := roles[role]; ok {
+			return nil
+		}
+	}
+
+	return ErrStatusMissingAnyRole
+}
@@ -0,0 +1,125 @@
+/*
+ * SPDX-License-Identifier: AGPL-3.0-or-later
+ * Copyright 2020 Kopano and its licensors
+ */
+
+package kcoidc
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRoles(t *testing.T) {
+	for _, tc := range []struct {
+		claims     ExtraClaimsWithType
+		claimPaths []string
+		roles      map[string]bool
+	}{
+		{ExtraClaimsWithType{}, nil, map[string]bool{}},
+		{ExtraClaimsWithType{"roles": "admin"}, nil, map[string]bool{"admin": true}},
+		{ExtraClaimsWithType{"roles": ""}, nil, map[string]bool{}},
+		{ExtraClaimsWithType{"roles": []interface{}{"admin", "user", "", 1}}, nil, map[string]bool{"admin": true, "user": true}},
+		{ExtraClaimsWithType{"roles": 1}, nil, map[string]bool{}},
+		{ExtraClaimsWithType{
+			"roles":  []interface{}{"admin"},
+			"groups": []interface{}{"staff"},
+			"realm_access": map[string]interface{}{
+				"roles": []interface{}{"offline_access"},
+			},
+		}, nil, map[string]bool{"admin": true, "staff": true, "offline_access": true}},
+		{ExtraClaimsWithType{
+			"roles": []interface{}{"admin"},
+			"resource_access": map[string]interface{}{
+				"app": map[string]interface{}{
+					"roles": "editor",
+				},
+			},
+		}, []string{"resource_access.app.roles"}, map[string]bool{"editor": true}},
+		{ExtraClaimsWithType{
+			"realm_access": "admin",
+		}, []string{"realm_access.roles"}, map[string]bool{}},
+	} {
+		if roles := tc.claims.Roles(tc.claimPaths...); !reflect.DeepEqual(roles, tc.roles) {
+			t.Errorf("unexpected roles for %v at %v: got %v, want %v", tc.claims, tc.claimPaths, roles, tc.roles)
+		}
+	}
+}
+
+func TestRequireRolesInClaims(t *testing.T) {
+	claims := &ExtraClaimsWithType{
+		"roles": []interface{}{"admin", "user"},
+		"realm_access": map[string]interface{}{
+			"roles": "auditor",
+		},
+		"app": map[string]interface{}{
+			"groups": []interface{}{"staff"},
+		},
+	}
+
+	for _, tc := range []struct {
+		roles      []string
+		claimPaths []string
+		all        error
+		any        error
+	}{
+		{nil, nil, nil, nil},
+		{[]string{"admin"}, nil, nil, nil},
+		{[]string{"admin", "auditor"}, nil, nil, nil},
+		{[]string{"admin", "missing"}, nil, ErrStatusMissingRequiredRole, nil},
+		{[]string{"missing", "other"}, nil, ErrStatusMissingRequiredRole, ErrStatusMissingAnyRole},
+		{[]string{"staff"}, nil, ErrStatusMissingRequiredRole, ErrStatusMissingAnyRole},
+		{[]string{"staff"}, []string{"app.groups"}, nil, nil},
+		{[]string{"staff", "admin"}, []string{"app.groups"}, ErrStatusMissingRequiredRole, nil},
+	} {
+		if err := RequireRolesInClaims(claims, tc.roles, tc.claimPaths...); err != tc.all {
+			t.Errorf("unexpected result requiring all of %v at %v: got %v, want %v", tc.roles, tc.claimPaths, err, tc.all)
+		}
+		if err := RequireAnyRoleInClaims(claims, tc.roles, tc.claimPaths...); err != tc.any {
+			t.Errorf("unexpected result requiring any of %v at %v: got %v, want %v", tc.roles, tc.claimPaths, err, tc.any)
+		}
+	}
+}
+
+func TestProviderRoleClaimPaths(t *testing.T) {
+	p, err := NewProvider(nil, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := &ExtraClaimsWithType{
+		"roles": []interface{}{"admin"},
+		"resource_access": map[string]interface{}{
+			"app": map[string]interface{}{
+				"roles": []interface{}{"editor"},
+			},
+		},
+	}
+
+	if roles := p.RolesFromClaims(claims); !reflect.DeepEqual(roles, map[string]bool{"admin": true}) {
+		t.Errorf("unexpected roles with default claim paths: %v", roles)
+	}
+	if err = p.RequireRolesInClaims(claims, []string{"editor"}); err != ErrStatusMissingRequiredRole {
+		t.Errorf("unexpected error with default claim paths: %v", err)
+	}
+
+	if err = p.SetRoleClaimPaths("resource_access.app.roles"); err != nil {
+		t.Fatal(err)
+	}
+	if roles := p.RolesFromClaims(claims); !reflect.DeepEqual(roles, map[string]bool{"editor": true}) {
+		t.Errorf("unexpected roles with configured claim paths: %v", roles)
+	}
+	if err = p.RequireRolesInClaims(claims, []string{"editor"}); err != nil {
+		t.Errorf("unexpected error with configured claim paths: %v", err)
+	}
+	if err = p.RequireAnyRoleInClaims(claims, []string{"admin", "viewer"}); err != ErrStatusMissingAnyRole {
+		t.Errorf("unexpected error with configured claim paths: %v", err)
+	}
+
+	// Reset to the defaults.
+	if err = p.SetRoleClaimPaths(); err != nil {
+		t.Fatal(err)
+	}
+	if err = p.RequireAnyRoleInClaims(claims, []string{"admin", "viewer"}); err != nil {
+		t.Errorf("unexpected error after reset: %v", err)
+	}
+}
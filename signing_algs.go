@@ -0,0 +1,58 @@
+/*
+ * SPDX-License-Identifier: AGPL-3.0-or-later
+ * Copyright 2020 Kopano and its licensors
+ */
+
+package kcoidc
+
+import (
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// SigningAlgNone is the JWS algorithm value of unsecured tokens.
+const SigningAlgNone = "none"
+
+// isSigningAlgForbidden returns true for algorithms which must never be
+// accepted, since tokens are always verified with public keys. These are the
+// unsecured "none" algorithm and all HMAC algorithms.
+func isSigningAlgForbidden(alg string) bool {
+	return strings.EqualFold(alg, SigningAlgNone) || strings.HasPrefix(strings.ToUpper(alg), "HS")
+}
+
+// isSigningMethodForbidden returns true for signing methods which must never
+// be accepted, see isSigningAlgForbidden.
+func isSigningMethodForbidden(method jwt.SigningMethod) bool {
+	if method == nil || method == jwt.SigningMethodNone {
+		return true
+	}
+	if _, ok := method.(*jwt.SigningMethodHMAC); ok {
+		return true
+	}
+
+	return isSigningAlgForbidden(method.Alg())
+}
+
+// effectiveSigningAlgs returns the signing algorithms which are accepted when
+// configured algorithms are combined with the provided algorithms from
+// discovery. Without configured algorithms, the discovered algorithms are used.
+// Otherwise the configured algorithms either override or are intersected with
+// the discovered algorithms.
+func effectiveSigningAlgs(configured, discovered []string, override bool) []string {
+	if len(configured) == 0 {
+		return discovered
+	}
+	if override {
+		return configured
+	}
+
+	var algs []string
+	for _, alg := range configured {
+		if isStringInSlice(discovered, alg) {
+			algs = append(algs, alg)
+		}
+	}
+
+	return algs
+}
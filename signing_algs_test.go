@@ -0,0 +1,53 @@
+/*
+ * SPDX-License-Identifier: AGPL-3.0-or-later
+ * Copyright 2020 Kopano and its licensors
+ */
+
+package kcoidc
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+func TestEffectiveSigningAlgs(t *testing.T) {
+	discovered := []string{"RS256", "ES256", "PS256"}
+
+	for _, tc := range []struct {
+		configured []string
+		override   bool
+		algs       []string
+	}{
+		{nil, false, discovered},
+		{[]string{"ES256", "EdDSA"}, false, []string{"ES256"}},
+		{[]string{"ES256", "EdDSA"}, true, []string{"ES256", "EdDSA"}},
+		{[]string{"EdDSA"}, false, nil},
+	} {
+		if algs := effectiveSigningAlgs(tc.configured, discovered, tc.override); !reflect.DeepEqual(algs, tc.algs) {
+			t.Errorf("unexpected algs for %v (override %v): got %v, want %v", tc.configured, tc.override, algs, tc.algs)
+		}
+	}
+}
+
+func TestIsSigningMethodForbidden(t *testing.T) {
+	for _, tc := range []struct {
+		method    jwt.SigningMethod
+		forbidden bool
+	}{
+		{jwt.SigningMethodNone, true},
+		{jwt.SigningMethodHS256, true},
+		{jwt.SigningMethodRS256, false},
+		{jwt.SigningMethodES256, false},
+	} {
+		if forbidden := isSigningMethodForbidden(tc.method); forbidden != tc.forbidden {
+			t.Errorf("unexpected result for %v: got %v, want %v", tc.method.Alg(), forbidden, tc.forbidden)
+		}
+	}
+
+	p, _ := NewProvider(nil, nil, false)
+	if err := p.SetSigningAlgs([]string{"RS256", "none"}, nil, true); err != ErrStatusInvalidSigningAlg {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
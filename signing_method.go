@@ -6,13 +6,16 @@
 package kcoidc
 
 import (
+	"crypto/ed25519"
 	"crypto/rsa"
+	"errors"
 
 	"github.com/dgrijalva/jwt-go"
 )
 
 func init() {
 	fixupRSAPSSSaltLength()
+	registerEdDSA()
 }
 
 func fixupRSAPSSSaltLength() {
@@ -27,3 +30,56 @@ func fixupRSAPSSSaltLength() {
 		}
 	}
 }
+
+// ErrEdDSAVerification is the error returned when an EdDSA signature does not
+// verify.
+var ErrEdDSAVerification = errors.New("eddsa: verification error")
+
+// SigningMethodEdDSA implements the EdDSA signing method with Ed25519 keys as
+// defined in RFC 8037. It is not provided by jwt-go.
+type SigningMethodEdDSA struct{}
+
+// SigningMethodEd25519 is the registered EdDSA signing method.
+var SigningMethodEd25519 = &SigningMethodEdDSA{}
+
+func registerEdDSA() {
+	jwt.RegisterSigningMethod(SigningMethodEd25519.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEd25519
+	})
+}
+
+// Alg returns the JWS algorithm name of the accociated signing method.
+func (m *SigningMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+// Verify verifies the provided signature of the provided signing string with
+// the provided key, which must be an ed25519.PublicKey.
+func (m *SigningMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	publicKey, ok := key.(ed25519.PublicKey)
+	if !ok || len(publicKey) != ed25519.PublicKeySize {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(publicKey, []byte(signingString), sig) {
+		return ErrEdDSAVerification
+	}
+
+	return nil
+}
+
+// Sign signs the provided signing string with the provided key, which must be
+// an ed25519.PrivateKey, and returns the encoded signature.
+func (m *SigningMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok || len(privateKey) != ed25519.PrivateKeySize {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(privateKey, []byte(signingString))), nil
+}
@@ -0,0 +1,76 @@
+/*
+ * SPDX-License-Identifier: AGPL-3.0-or-later
+ * Copyright 2020 Kopano and its licensors
+ */
+
+package kcoidc
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/openkop/oidc-go"
+	"gopkg.in/square/go-jose.v2"
+)
+
+// Staleness policies define how a Provider behaves once its discovery document
+// and JWKS could not be refreshed for longer than the max staleness.
+const (
+	StalenessPolicyAllow = iota
+	StalenessPolicyDeny
+)
+
+// checkDefinition returns nil if the provided provider definition is usable
+// for token validation. Definitions which are not, are treated like failed
+// refreshes to keep the last known good definition. An empty JWKS is usable
+// if the provided extra keys are not empty.
+func checkDefinition(definition *oidc.ProviderDefinition, extraKeys []jose.JSONWebKey) error {
+	switch {
+	case definition == nil || definition.WellKnown == nil:
+		return fmt.Errorf("missing discovery document")
+	case definition.JWKS == nil:
+		return fmt.Errorf("missing jwks")
+	case len(definition.JWKS.Keys) == 0 && len(extraKeys) == 0:
+		return fmt.Errorf("missing jwks keys")
+	}
+
+	return nil
+}
+
+// refreshFailed records the provided refresh error. The associated Provider
+// is stale from the first failed refresh until the next successful one. It
+// must be called with the mutex of the Provider locked.
+func (p *Provider) refreshFailed(err error, now time.Time) {
+	p.lastRefreshError = err
+	if p.staleSince.IsZero() {
+		p.staleSince = now
+	}
+	if p.logger != nil {
+		p.logger.Printf("kcoidc provider refresh failed, keeping last known definition: %v\n", err)
+	}
+}
+
+// refreshSucceeded records a successful refresh. It must be called with the
+// mutex of the Provider locked.
+func (p *Provider) refreshSucceeded(now time.Time) {
+	p.lastUpdated = now
+	p.lastRefreshError = nil
+	p.staleSince = time.Time{}
+}
+
+// staleness returns for how long the associated Provider is stale at the
+// provided time, or zero if it is not stale. A Provider is stale while its
+// refreshes fail and the staleness is measured from its last successful
+// refresh. It must be called with the mutex of the Provider at least read
+// locked.
+func (p *Provider) staleness(now time.Time) time.Duration {
+	if p.staleSince.IsZero() {
+		return 0
+	}
+	if p.lastUpdated.IsZero() {
+		// Never refreshed successfully, so stale since the first failure.
+		return now.Sub(p.staleSince)
+	}
+
+	return now.Sub(p.lastUpdated)
+}
@@ -0,0 +1,81 @@
+/*
+ * SPDX-License-Identifier: AGPL-3.0-or-later
+ * Copyright 2020 Kopano and its licensors
+ */
+
+package kcoidc
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// A Status describes the current state of a Provider.
+type Status struct {
+	Initialized bool   `json:"initialized"`
+	Ready       bool   `json:"ready"`
+	Issuer      string `json:"issuer,omitempty"`
+
+	// LastUpdated is the time of the last successful refresh. Stale is set
+	// while refreshing fails, since StaleSince, with the error of the last
+	// failed refresh. StaleSeconds counts from LastUpdated and
+	// StalenessExceeded is set when it is longer than the configured max
+	// staleness. Until ready, LastError is the error of the
+	// last failed initialization attempt.
+	LastUpdated       int64  `json:"last_updated,omitempty"`
+	Stale             bool   `json:"stale"`
+	StaleSince        int64  `json:"stale_since,omitempty"`
+	StaleSeconds      int64  `json:"stale_seconds,omitempty"`
+	StalenessExceeded bool   `json:"staleness_exceeded"`
+	LastError         string `json:"last_error,omitempty"`
+
+	// Keys lists all keys of the JWKS and all extra keys, including the ones
+	// which are excluded from key selection.
+	Keys []*KeyStatus `json:"keys"`
+
+	// RetiredKeyValidations counts the tokens which were successfully
+	// validated with a retired key.
+	RetiredKeyValidations uint64 `json:"retired_key_validations"`
+}
+
+// Status returns the current Status of the associated Provider.
+func (p *Provider) Status() *Status {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+
+	status := &Status{
+		Initialized: p.initialized,
+		Keys:        make([]*KeyStatus, 0, len(p.keys.status)),
+
+		RetiredKeyValidations: atomic.LoadUint64(&p.retiredKeyValidations),
+	}
+	now := time.Now()
+	if !p.lastUpdated.IsZero() {
+		status.LastUpdated = p.lastUpdated.Unix()
+	}
+	if staleness := p.staleness(now); staleness > 0 {
+		status.Stale = true
+		status.StaleSince = p.staleSince.Unix()
+		status.StaleSeconds = int64(staleness.Seconds())
+		status.StalenessExceeded = p.maxStaleness > 0 && staleness > p.maxStaleness
+	}
+	if p.lastRefreshError != nil {
+		status.LastError = p.lastRefreshError.Error()
+	} else if p.initializeError != nil {
+		status.LastError = p.initializeError.Error()
+	}
+	if p.definition != nil && p.definition.WellKnown != nil {
+		status.Ready = p.definition.JWKS != nil
+		status.Issuer = p.definition.WellKnown.Issuer
+	}
+	for _, keyStatus := range p.keys.status {
+		if keyStatus.RetiredUntil != 0 && keyStatus.RetiredUntil <= now.Unix() {
+			// Expired retired keys are no longer relevant.
+			continue
+		}
+		s := *keyStatus
+		status.Keys = append(status.Keys, &s)
+	}
+
+	return status
+}
@@ -7,6 +7,7 @@ package kcoidc
 
 import (
 	"encoding/json"
+	"strconv"
 )
 
 func popFromMap(m map[string]interface{}, k string) (interface{}, bool) {
@@ -19,6 +20,29 @@ func popFromMap(m map[string]interface{}, k string) (interface{}, bool) {
 	return v, true
 }
 
+// valueFromMapPath returns the value found at the provided dot separated path
+// in the provided map. As claim names can contain dots themselves, the full
+// path is tried as name first, followed by all possible splits at dots starting
+// with the shortest name.
+func valueFromMapPath(m map[string]interface{}, path string) (interface{}, bool) {
+	if v, ok := m[path]; ok {
+		return v, true
+	}
+
+	for i := 0; i < len(path); i++ {
+		if path[i] != '.' {
+			continue
+		}
+		if sub, _ := m[path[:i]].(map[string]interface{}); sub != nil {
+			if v, ok := valueFromMapPath(sub, path[i+1:]); ok {
+				return v, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
 func popStringFromMap(m map[string]interface{}, k string) string {
 	v, ok := popFromMap(m, k)
 	if !ok {
@@ -36,10 +60,104 @@ func popInt64FromMap(m map[string]interface{}, k string) int64 {
 		case float64:
 			return int64(vt)
 		case json.Number:
-			n, _ := vt.Int64()
-			return n
+			if n, err := vt.Int64(); err == nil {
+				return n
+			}
+			n, _ := vt.Float64()
+			return int64(n)
 		}
 	}
 
 	return 0
 }
+
+func copyValue(v interface{}) interface{} {
+	switch vt := v.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(vt))
+		for k, v := range vt {
+			m[k] = copyValue(v)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(vt))
+		for i, v := range vt {
+			s[i] = copyValue(v)
+		}
+		return s
+	}
+
+	return v
+}
+
+func isStringInSlice(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+
+	return false
+}
+
+func stringFromValue(v interface{}) (string, bool) {
+	switch vt := v.(type) {
+	case string:
+		return vt, vt != ""
+	case json.Number:
+		return vt.String(), true
+	case float64:
+		return strconv.FormatFloat(vt, 'f', -1, 64), true
+	}
+
+	return "", false
+}
+
+func numberFromValue(v interface{}) (float64, bool) {
+	switch vt := v.(type) {
+	case float64:
+		return vt, true
+	case json.Number:
+		n, err := vt.Float64()
+		return n, err == nil
+	}
+
+	return 0, false
+}
+
+func int64FromValue(v interface{}) (int64, bool) {
+	switch vt := v.(type) {
+	case json.Number:
+		n, err := vt.Int64()
+		return n, err == nil
+	case float64:
+		n := int64(vt)
+		return n, float64(n) == vt
+	}
+
+	return 0, false
+}
+
+func claimValuesEqual(value, expected interface{}) bool {
+	if n, ok := int64FromValue(value); ok {
+		// Compare integers exactly, to not lose precision beyond 2^53.
+		if e, ok := int64FromValue(expected); ok {
+			return n == e
+		}
+	}
+	if n, ok := numberFromValue(value); ok {
+		e, ok := numberFromValue(expected)
+		return ok && n == e
+	}
+
+	switch vt := value.(type) {
+	case string:
+		e, ok := expected.(string)
+		return ok && vt == e
+	case bool:
+		e, ok := expected.(bool)
+		return ok && vt == e
+	}
+
+	return false
+}